@@ -0,0 +1,95 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+const (
+	AuditEventDropletDeleted     = "droplet_deleted"
+	AuditEventDropletSnapshotted = "droplet_snapshotted"
+	AuditEventSnapshotExpired    = "snapshot_expired"
+)
+
+type AuditEntry struct {
+	Time     time.Time        `json:"time"`
+	Event    string           `json:"event"`
+	Droplet  *client.Instance `json:"droplet,omitempty"`
+	Snapshot *client.Snapshot `json:"snapshot,omitempty"`
+}
+
+type AuditLogInterface interface {
+	Record(AuditEntry) error
+}
+
+// FileAuditLog appends audit entries, as JSON documents separated with new
+// lines, to the configured file
+type FileAuditLog struct {
+	path string
+	lock sync.Mutex
+}
+
+func (l *FileAuditLog) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func NewFileAuditLog(path string) *FileAuditLog {
+	return &FileAuditLog{
+		path: path,
+	}
+}
+
+func (c *HangingDropletsCleaner) SetAuditLog(auditLog AuditLogInterface) {
+	c.auditLog = auditLog
+}
+
+func (c *HangingDropletsCleaner) audit(event string, droplet *client.Instance, snapshot *client.Snapshot) {
+	fields := logrus.Fields{"event": event}
+	if droplet != nil {
+		fields["droplet"] = droplet.Name
+		fields["droplet_id"] = droplet.ID
+	}
+	if snapshot != nil {
+		fields["snapshot"] = snapshot.Name
+		fields["snapshot_id"] = snapshot.ID
+	}
+	logrus.WithFields(fields).Infoln("Audit")
+
+	if c.auditLog == nil {
+		return
+	}
+
+	err := c.auditLog.Record(AuditEntry{
+		Time:     time.Now(),
+		Event:    event,
+		Droplet:  droplet,
+		Snapshot: snapshot,
+	})
+	if err != nil {
+		logrus.Errorf("Error while writing audit log entry '%s': %v", event, err.Error())
+	}
+}
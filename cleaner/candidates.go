@@ -0,0 +1,148 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+type candidate struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Observations int       `json:"observations"`
+}
+
+// candidateTracker implements the "mark" part of mark-then-delete: a droplet
+// is acted on only after it was found hanging in a number of consecutive
+// passes and/or for a minimal time. The state is saved to a file (if
+// configured), so restarting the cleaner doesn't reset the observations
+type candidateTracker struct {
+	path                 string
+	requiredObservations int
+	minDuration          time.Duration
+
+	candidates map[string]*candidate
+}
+
+func (t *candidateTracker) load() error {
+	if t.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var candidates []*candidate
+	err = json.Unmarshal(data, &candidates)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		t.candidates[c.ID] = c
+	}
+
+	return nil
+}
+
+func (t *candidateTracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	candidates := make([]*candidate, 0, len(t.candidates))
+	for _, c := range t.candidates {
+		candidates = append(candidates, c)
+	}
+
+	data, err := json.MarshalIndent(candidates, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), t.path)
+}
+
+func (t *candidateTracker) isReady(c *candidate, now time.Time) bool {
+	return c.Observations >= t.requiredObservations && now.Sub(c.FirstSeen) >= t.minDuration
+}
+
+// observe records the droplets found hanging in the current pass and returns
+// the ones that were hanging long enough. Candidates that weren't found
+// hanging in this pass are forgotten
+func (t *candidateTracker) observe(droplets []client.Instance, now time.Time) (ready []client.Instance) {
+	seen := make(map[string]*candidate)
+
+	for _, droplet := range droplets {
+		c, ok := t.candidates[droplet.ID]
+		if !ok {
+			c = &candidate{
+				ID:        droplet.ID,
+				Name:      droplet.Name,
+				FirstSeen: now,
+			}
+		}
+
+		c.LastSeen = now
+		c.Observations++
+		seen[droplet.ID] = c
+
+		if t.isReady(c, now) {
+			ready = append(ready, droplet)
+			continue
+		}
+
+		logrus.Infof("Droplet '%s' is hanging (observation %d/%d, since %s); waiting before deleting it", droplet.Name, c.Observations, t.requiredObservations, c.FirstSeen.Format(time.RFC3339))
+	}
+
+	t.candidates = seen
+
+	return
+}
+
+func newCandidateTracker(path string, requiredObservations int, minDuration time.Duration) (*candidateTracker, error) {
+	if requiredObservations < 1 {
+		requiredObservations = 1
+	}
+
+	tracker := &candidateTracker{
+		path:                 path,
+		requiredObservations: requiredObservations,
+		minDuration:          minDuration,
+		candidates:           make(map[string]*candidate),
+	}
+
+	err := tracker.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return tracker, nil
+}
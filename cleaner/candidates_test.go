@@ -0,0 +1,69 @@
+package cleaner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+func TestCandidateTrackerRequiresConsecutiveObservations(t *testing.T) {
+	tracker, err := newCandidateTracker("", 2, 0)
+	require.NoError(t, err)
+
+	droplet1 := client.Instance{ID: "1", Name: "runner-1"}
+	droplet2 := client.Instance{ID: "2", Name: "runner-2"}
+	now := time.Now()
+
+	assert.Empty(t, tracker.observe([]client.Instance{droplet1, droplet2}, now))
+	assert.Equal(t, []client.Instance{droplet1}, tracker.observe([]client.Instance{droplet1}, now.Add(time.Minute)))
+	assert.Empty(t, tracker.observe([]client.Instance{droplet2}, now.Add(2*time.Minute)), "Observations should be reset when droplet is not hanging in a pass")
+}
+
+func TestCandidateTrackerRequiresMinDuration(t *testing.T) {
+	tracker, err := newCandidateTracker("", 1, 10*time.Minute)
+	require.NoError(t, err)
+
+	droplet := client.Instance{ID: "1", Name: "runner-1"}
+	now := time.Now()
+
+	assert.Empty(t, tracker.observe([]client.Instance{droplet}, now))
+	assert.Empty(t, tracker.observe([]client.Instance{droplet}, now.Add(5*time.Minute)))
+	assert.Equal(t, []client.Instance{droplet}, tracker.observe([]client.Instance{droplet}, now.Add(10*time.Minute)))
+}
+
+func TestCandidateTrackerIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "candidates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	droplet := client.Instance{ID: "1", Name: "runner-1"}
+
+	tracker, err := newCandidateTracker(stateFile, 2, 0)
+	require.NoError(t, err)
+	assert.Empty(t, tracker.observe([]client.Instance{droplet}, time.Now()))
+	require.NoError(t, tracker.save())
+
+	tracker, err = newCandidateTracker(stateFile, 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []client.Instance{droplet}, tracker.observe([]client.Instance{droplet}, time.Now()), "Observations should survive restart")
+}
+
+func TestCandidateTrackerWithCorruptedState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "candidates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	require.NoError(t, ioutil.WriteFile(stateFile, []byte("{"), 0600))
+
+	_, err = newCandidateTracker(stateFile, 2, 0)
+	assert.Error(t, err)
+}
@@ -2,13 +2,13 @@ package cleaner
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/digitalocean/godo"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
@@ -29,6 +29,104 @@ var (
 		nil,
 	)
 
+	numberOfStopDropletTimeouts = prometheus.NewDesc(
+		"hanging_droplets_cleaner_stop_droplet_timeouts_total",
+		"Total number of droplets stopping actions that didn't complete in time",
+		[]string{},
+		nil,
+	)
+
+	numberOfPhantomDeletes = prometheus.NewDesc(
+		"hanging_droplets_cleaner_phantom_deletes_total",
+		"Total number of successful droplet deletes that didn't really remove the droplet",
+		[]string{},
+		nil,
+	)
+
+	numberOfUndeletableDroplets = prometheus.NewDesc(
+		"hanging_droplets_cleaner_undeletable_droplets_total",
+		"Total number of droplets that survived all deletion retries",
+		[]string{},
+		nil,
+	)
+
+	numberOfQuarantinedDroplets = prometheus.NewDesc(
+		"hanging_droplets_cleaner_quarantined_droplets_total",
+		"Total number of droplets stopped and tagged for quarantine",
+		[]string{},
+		nil,
+	)
+
+	numberOfSnapshots = prometheus.NewDesc(
+		"hanging_droplets_cleaner_snapshots_total",
+		"Total number of snapshots taken before deleting droplets",
+		[]string{},
+		nil,
+	)
+
+	numberOfSnapshotErrors = prometheus.NewDesc(
+		"hanging_droplets_cleaner_snapshot_errors_total",
+		"Total number of droplets snapshot errors",
+		[]string{},
+		nil,
+	)
+
+	numberOfExpiredSnapshots = prometheus.NewDesc(
+		"hanging_droplets_cleaner_expired_snapshots_total",
+		"Total number of removed expired snapshots",
+		[]string{},
+		nil,
+	)
+
+	deletionLimitExceeded = prometheus.NewDesc(
+		"hanging_droplets_cleaner_deletion_limit_exceeded",
+		"Whether the last cleanup refused to delete droplets because of the deletion limit",
+		[]string{},
+		nil,
+	)
+
+	numberOfDeletionLimitTrips = prometheus.NewDesc(
+		"hanging_droplets_cleaner_deletion_limit_trips_total",
+		"Total number of cleanups that refused to delete droplets because of the deletion limit",
+		[]string{},
+		nil,
+	)
+
+	numberOfMachinesDirectoryErrors = prometheus.NewDesc(
+		"hanging_droplets_cleaner_machines_directory_errors_total",
+		"Total number of cleanups aborted because machines directory looked invalid (e.g. not mounted)",
+		[]string{},
+		nil,
+	)
+
+	numberOfMachines = prometheus.NewDesc(
+		"hanging_droplets_cleaner_machines",
+		"Number of machines found during the last cleanup, by state",
+		[]string{"state"},
+		nil,
+	)
+
+	numberOfSupersededDroplets = prometheus.NewDesc(
+		"hanging_droplets_cleaner_superseded_droplets",
+		"Number of droplets found during the last cleanup with a name of an existing machine, but a different ID",
+		[]string{},
+		nil,
+	)
+
+	numberOfZombieFolders = prometheus.NewDesc(
+		"hanging_droplets_cleaner_zombie_folders_total",
+		"Total number of removed or archived machine folders without droplets",
+		[]string{},
+		nil,
+	)
+
+	numberOfProtectedDroplets = prometheus.NewDesc(
+		"hanging_droplets_cleaner_protected_droplets",
+		"Number of protected droplets found during the last cleanup",
+		[]string{},
+		nil,
+	)
+
 	numberOfRemoveDropletErrors = prometheus.NewDesc(
 		"hanging_droplets_cleaner_remove_droplet_errors_total",
 		"Total number of droplets removing errors",
@@ -37,49 +135,255 @@ var (
 	)
 )
 
+type StopMode string
+
+const (
+	// StopModePowerOff powers off the droplet and waits for the action to
+	// finish before deleting it
+	StopModePowerOff StopMode = "power-off"
+	// StopModeSkip deletes the droplet without powering it off first
+	StopModeSkip StopMode = "skip"
+)
+
+// DefaultConcurrency is the number of droplets stopped and deleted in
+// parallel when configured from the command line
+const DefaultConcurrency = 10
+
 type HangingDropletsCleaner struct {
-	client         client.DigitalOceanClientInterface
+	// counters are updated by the deletion workers with sync/atomic, so
+	// they're kept first to be 64-bit aligned on 32-bit platforms
+	totalNumberOfRemovedDroplets     int64
+	totalNumberOfStopDropletErrors   int64
+	totalNumberOfStopDropletTimeouts int64
+	totalNumberOfRemoveDropletErrors int64
+	totalNumberOfPhantomDeletes      int64
+	totalNumberOfUndeletableDroplets int64
+	totalNumberOfQuarantinedDroplets int64
+	totalNumberOfSnapshots           int64
+	totalNumberOfSnapshotErrors      int64
+	totalNumberOfExpiredSnapshots    int64
+	totalNumberOfDeletionLimitTrips  int64
+	deletionLimitExceeded            int64
+	totalNumberOfMachinesDirErrors   int64
+	numberOfSupersededDroplets       int64
+	totalNumberOfZombieFolders       int64
+	numberOfProtectedDroplets        int64
+
+	client         client.CloudProvider
 	machinesFinder MachinesFinderInterface
+	machineStore   MachineStoreInterface
 
 	delete             bool
 	runnerPrefix       []string
 	runnerPrefixRegexp *regexp.Regexp
 	dropletAge         time.Duration
-
-	totalNumberOfRemovedDroplets     int64
-	totalNumberOfStopDropletErrors   int64
-	totalNumberOfRemoveDropletErrors int64
+	stopMode           StopMode
+	policy             Policy
+	supersededPolicy   SupersededPolicy
+	quarantineHold     time.Duration
+	concurrency        int
+	requiredTags       []string
+	protectedTags      map[string]bool
+	protectedDroplets  map[string]bool
+	candidates         *candidateTracker
+	snapshotPrefixes   []string
+	snapshotRetention  time.Duration
+	auditLog           AuditLogInterface
+
+	zombieFolderMinAge           time.Duration
+	zombieFolderArchiveDirectory string
+	zombieFolderArchiveRetention time.Duration
+
+	machinesLock    sync.Mutex
+	machinesByState map[MachineState]int
+
+	maxDeletions           int
+	maxDeletionsPercent    float64
+	overrideDeletionLimits bool
+
+	verifyDeletes        bool
+	verifyDelay          time.Duration
+	verifyMaxFailures    int
+	verificationsLock    sync.Mutex
+	pendingVerifications []*deletionVerification
+	notifier             NotifierInterface
 }
 
 func (c *HangingDropletsCleaner) Describe(ch chan<- *prometheus.Desc) {
 	ch <- numberOfRemovedDroplets
 	ch <- numberOfStopDropletErrors
+	ch <- numberOfStopDropletTimeouts
 	ch <- numberOfRemoveDropletErrors
+	ch <- numberOfPhantomDeletes
+	ch <- numberOfUndeletableDroplets
+	ch <- numberOfQuarantinedDroplets
+	ch <- numberOfSnapshots
+	ch <- numberOfSnapshotErrors
+	ch <- numberOfExpiredSnapshots
+	ch <- deletionLimitExceeded
+	ch <- numberOfDeletionLimitTrips
+	ch <- numberOfMachinesDirectoryErrors
+	ch <- numberOfMachines
+	ch <- numberOfSupersededDroplets
+	ch <- numberOfZombieFolders
+	ch <- numberOfProtectedDroplets
+
+	if collector, ok := c.client.(prometheus.Collector); ok {
+		collector.Describe(ch)
+	}
 }
 
 func (c *HangingDropletsCleaner) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		numberOfRemovedDroplets,
 		prometheus.CounterValue,
-		float64(c.totalNumberOfRemovedDroplets),
+		float64(atomic.LoadInt64(&c.totalNumberOfRemovedDroplets)),
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		numberOfStopDropletErrors,
 		prometheus.CounterValue,
-		float64(c.totalNumberOfStopDropletErrors),
+		float64(atomic.LoadInt64(&c.totalNumberOfStopDropletErrors)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfStopDropletTimeouts,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfStopDropletTimeouts)),
 	)
 
 	ch <- prometheus.MustNewConstMetric(
 		numberOfRemoveDropletErrors,
 		prometheus.CounterValue,
-		float64(c.totalNumberOfRemoveDropletErrors),
+		float64(atomic.LoadInt64(&c.totalNumberOfRemoveDropletErrors)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfPhantomDeletes,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfPhantomDeletes)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfUndeletableDroplets,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfUndeletableDroplets)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfQuarantinedDroplets,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfQuarantinedDroplets)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfSnapshots,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfSnapshots)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfSnapshotErrors,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfSnapshotErrors)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfExpiredSnapshots,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfExpiredSnapshots)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		deletionLimitExceeded,
+		prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&c.deletionLimitExceeded)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfDeletionLimitTrips,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfDeletionLimitTrips)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfMachinesDirectoryErrors,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfMachinesDirErrors)),
+	)
+
+	c.machinesLock.Lock()
+	for _, state := range machineStates {
+		ch <- prometheus.MustNewConstMetric(
+			numberOfMachines,
+			prometheus.GaugeValue,
+			float64(c.machinesByState[state]),
+			string(state),
+		)
+	}
+	c.machinesLock.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfSupersededDroplets,
+		prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&c.numberOfSupersededDroplets)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfZombieFolders,
+		prometheus.CounterValue,
+		float64(atomic.LoadInt64(&c.totalNumberOfZombieFolders)),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		numberOfProtectedDroplets,
+		prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&c.numberOfProtectedDroplets)),
 	)
+
+	if collector, ok := c.client.(prometheus.Collector); ok {
+		collector.Collect(ch)
+	}
 }
 
-func (c *HangingDropletsCleaner) shouldRemoveDroplet(droplet godo.Droplet, machines []Machine) bool {
+func (c *HangingDropletsCleaner) countMachines(machines []Machine) {
+	machinesByState := make(map[MachineState]int)
 	for _, machine := range machines {
-		if droplet.Name == machine.Name && machine.DropletId != 0 {
+		machinesByState[machine.State]++
+	}
+
+	c.machinesLock.Lock()
+	c.machinesByState = machinesByState
+	c.machinesLock.Unlock()
+
+	if machinesByState[MachineStateCorrupt] > 0 {
+		logrus.Warnf("Found %d machines with corrupted configuration", machinesByState[MachineStateCorrupt])
+	}
+}
+
+func (c *HangingDropletsCleaner) selectOldDroplets(droplets []client.Instance) (oldDroplets []client.Instance) {
+	for _, droplet := range droplets {
+		if droplet.CreatedAt.IsZero() || time.Since(droplet.CreatedAt) < c.dropletAge {
+			continue
+		}
+
+		oldDroplets = append(oldDroplets, droplet)
+	}
+
+	return
+}
+
+func (c *HangingDropletsCleaner) hasRequiredTags(droplet client.Instance) bool {
+	for _, requiredTag := range c.requiredTags {
+		found := false
+		for _, tag := range droplet.Tags {
+			if tag == requiredTag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
 			return false
 		}
 	}
@@ -87,81 +391,212 @@ func (c *HangingDropletsCleaner) shouldRemoveDroplet(droplet godo.Droplet, machi
 	return true
 }
 
-func (c *HangingDropletsCleaner) stopDroplet(droplet godo.Droplet) {
+type dropletClass string
+
+const (
+	// dropletClassOwned is a droplet that belongs to an existing machine
+	dropletClassOwned dropletClass = "owned"
+	// dropletClassSkipped is a droplet that is not touched because of
+	// the configuration (e.g. protection) or because its machine is in
+	// an unknown state
+	dropletClassSkipped dropletClass = "skipped"
+	// dropletClassHanging is a droplet without a machine
+	dropletClassHanging dropletClass = "hanging"
+	// dropletClassSuperseded is a droplet with a name of an existing
+	// machine, but with a different ID, e.g. when the machine was
+	// recreated with the same name and the old droplet was left behind
+	dropletClassSuperseded dropletClass = "superseded"
+)
+
+func (c *HangingDropletsCleaner) classifyDroplet(droplet client.Instance, machines []Machine) dropletClass {
+	if c.isProtected(droplet) {
+		return dropletClassSkipped
+	}
+
+	if !c.hasRequiredTags(droplet) {
+		logrus.Debugf("Skipping droplet '%s': missing one of the required tags %v", droplet.Name, c.requiredTags)
+		return dropletClassSkipped
+	}
+
+	for _, machine := range machines {
+		if machine.InstanceID != "" && machine.InstanceID == droplet.ID {
+			return dropletClassOwned
+		}
+	}
+
+	for _, machine := range machines {
+		if droplet.Name != machine.Name {
+			continue
+		}
+
+		// machine that is being created, or whose configuration can't be
+		// read, may own the droplet
+		if machine.State == MachineStateCreating || machine.State == MachineStateCorrupt {
+			logrus.Debugf("Skipping droplet '%s': machine is in '%s' state", droplet.Name, machine.State)
+			return dropletClassSkipped
+		}
+
+		// the ID of a machine of another driver, or of an unknown one, can't
+		// be compared with the droplet's ID, so the machine may own it
+		if machineDriverName(machine) != droplet.Provider || machine.InstanceID == "" {
+			logrus.Debugf("Skipping droplet '%s': machine of the '%s' driver has no ID to compare with", droplet.Name, machineDriverName(machine))
+			return dropletClassSkipped
+		}
+
+		// Google instance IDs are built from the zone and the name, as the
+		// driver doesn't store the numeric ID, so an old instance can't be
+		// told apart from the one of a machine recreated in another zone
+		if droplet.Provider == client.GoogleProviderName {
+			logrus.Debugf("Skipping droplet '%s': superseded instances can't be detected for the '%s' provider", droplet.Name, droplet.Provider)
+			return dropletClassSkipped
+		}
+
+		logrus.Warnf("Droplet '%s' (ID: %s) is superseded by machine's droplet with ID %s", droplet.Name, droplet.ID, machine.InstanceID)
+		return dropletClassSuperseded
+	}
+
+	return dropletClassHanging
+}
+
+func (c *HangingDropletsCleaner) stopDroplet(droplet client.Instance) error {
+	if c.stopMode == StopModeSkip {
+		logrus.Debugf("Skipping stop of droplet '%s'", droplet.Name)
+		return nil
+	}
+
 	logrus.Debugf("Stopping droplet '%s'", droplet.Name)
 
-	if err := c.client.StopDroplet(droplet); err != nil {
-		c.totalNumberOfStopDropletErrors++
-		logrus.Errorf("Error while stopping droplet '%s': %v", droplet.Name, err.Error())
+	err := c.client.StopInstance(droplet)
+	if err == nil {
+		logrus.Debugf("Droplet '%s' stopped", droplet.Name)
+		return nil
+	}
+
+	atomic.AddInt64(&c.totalNumberOfStopDropletErrors, 1)
+	if client.IsActionTimeout(err) {
+		atomic.AddInt64(&c.totalNumberOfStopDropletTimeouts, 1)
 	}
+
+	logrus.Errorf("Error while stopping droplet '%s': %v", droplet.Name, err.Error())
+
+	return err
 }
 
-func (c *HangingDropletsCleaner) deleteDroplet(droplet godo.Droplet) {
+func (c *HangingDropletsCleaner) deleteDroplet(droplet client.Instance) error {
 	logrus.Debugf("Deleting droplet '%s'", droplet.Name)
 
-	if err := c.client.DeleteDroplet(droplet); err != nil {
-		c.totalNumberOfRemoveDropletErrors++
+	if err := c.client.DeleteInstance(droplet); err != nil {
+		atomic.AddInt64(&c.totalNumberOfRemoveDropletErrors, 1)
 		logrus.Errorf("Error while deleting droplet '%s': %v", droplet.Name, err.Error())
-		return
+		return err
 	}
 
-	c.totalNumberOfRemovedDroplets++
+	atomic.AddInt64(&c.totalNumberOfRemovedDroplets, 1)
+	c.audit(AuditEventDropletDeleted, &droplet, nil)
+	c.scheduleDeletionVerification(droplet)
+
+	return nil
 }
 
-func (c *HangingDropletsCleaner) stopAndDeleteDroplet(droplet godo.Droplet) {
-	logrus.Infof("Will stop and delete: %s (created_at: %s)", droplet.Name, droplet.Created)
+func (c *HangingDropletsCleaner) stopAndDeleteDroplet(droplet client.Instance) dropletResult {
+	result := dropletResult{droplet: droplet}
+
+	logrus.Infof("Will stop and delete: %s (created_at: %s)", droplet.Name, droplet.CreatedAt.Format(time.RFC3339))
 	if !c.delete {
-		return
+		return result
 	}
 
-	c.stopDroplet(droplet)
-	c.deleteDroplet(droplet)
+	result.stopErr = c.stopDroplet(droplet)
+	result.deleteErr = c.snapshotAndDeleteDroplet(droplet)
+	result.deleted = result.deleteErr == nil
+
+	return result
 }
 
-func (c *HangingDropletsCleaner) cleanDockerMachineFolder(machineDirectory, dropletName string) {
+func (c *HangingDropletsCleaner) cleanDockerMachineFolder(machine Machine) error {
+	if machine.Inventory != "" {
+		logrus.Debugf("Machine %s is listed by the %s inventory; skipping its folder", machine.Name, machine.Inventory)
+		return nil
+	}
 
-	dockerMachinePath := fmt.Sprintf("%s/%s", machineDirectory, dropletName)
+	err := c.machineStore.RemoveMachine(machine)
+	switch {
+	case err == nil:
+	case IsMachineLocked(err):
+		logrus.Infof("Machine %s is locked by another process; skipping its folder", machine.Name)
+	case IsMachineChangedError(err):
+		logrus.Warnf("Not cleaning up the machine folder: %v", err)
+	default:
+		logrus.Infof("Failed cleaning up machine: %s with error %+v", machine.Name, err)
+	}
 
-	if _, err := os.Stat(dockerMachinePath); !os.IsNotExist(err) {
-		logrus.Infof("Cleaning up the DockerMachine folder: %s", dockerMachinePath)
+	return err
+}
 
-		err := os.RemoveAll(dockerMachinePath)
-		if err != nil {
-			logrus.Infof("Failed cleaning up folder: %s with error %+v", dockerMachinePath, err)
+// listedMachine returns the machine with the given name found while listing
+// the machines, or a machine with empty state if there was none
+func listedMachine(machines []Machine, name string) Machine {
+	for _, machine := range machines {
+		if machine.Name == name {
+			return machine
 		}
 	}
+
+	return Machine{Name: name}
 }
 
-func (c *HangingDropletsCleaner) findAndDeleteHangingDroplets(droplets []godo.Droplet, machines []Machine, machineDirectory string) int64 {
-	removed := c.totalNumberOfRemovedDroplets
+// findAndDeleteHangingDroplets handles the droplets old enough to be deleted;
+// matched is the number of all droplets matching prefixes, which the
+// percentage deletion limit is computed against
+func (c *HangingDropletsCleaner) findAndDeleteHangingDroplets(droplets []client.Instance, matched int, machines []Machine) dropletResults {
+	var hangingDroplets []client.Instance
+	superseded := make(map[string]bool)
 	for _, droplet := range droplets {
-		if !c.shouldRemoveDroplet(droplet, machines) {
-			continue
+		switch c.classifyDroplet(droplet, machines) {
+		case dropletClassOwned:
+			c.releaseQuarantine(droplet)
+		case dropletClassHanging:
+			hangingDroplets = append(hangingDroplets, droplet)
+		case dropletClassSuperseded:
+			superseded[droplet.ID] = true
+			if c.supersededPolicy == SupersededPolicyDelete {
+				hangingDroplets = append(hangingDroplets, droplet)
+			}
 		}
-
-		c.stopAndDeleteDroplet(droplet)
-		c.cleanDockerMachineFolder(machineDirectory, droplet.Name)
 	}
+	atomic.StoreInt64(&c.numberOfSupersededDroplets, int64(len(superseded)))
 
-	return c.totalNumberOfRemovedDroplets - removed
-}
-
-func (c *HangingDropletsCleaner) findAndDeleteZombieFolders(droplets []godo.Droplet, machines []Machine, machineDirectory string) {
-
-	var dropletNames []string
-	for _, droplet := range droplets {
-		dropletNames = append(dropletNames, droplet.Name)
+	if c.candidates != nil {
+		hangingDroplets = c.candidates.observe(hangingDroplets, time.Now())
+		if err := c.candidates.save(); err != nil {
+			logrus.Errorf("Error while saving hanging droplets candidates: %v", err.Error())
+		}
 	}
 
-	logrus.Infof("Got %d droplets to sync with folders", len(dropletNames))
+	if !c.checkDeletionLimits(len(hangingDroplets), matched) {
+		return nil
+	}
 
-	for _, machine := range machines {
+	return c.processDroplets(hangingDroplets, func(droplet client.Instance) dropletResult {
+		var result dropletResult
+		if c.policy == PolicyQuarantine {
+			result = c.quarantineDroplet(droplet)
+		} else {
+			result = c.stopAndDeleteDroplet(droplet)
+		}
+		// machine folder of the superseded droplet belongs to the new one
+		if !c.delete || !result.deleted || superseded[droplet.ID] {
+			return result
+		}
 
-		if !c.stringInSlice(machine.Name, dropletNames) {
-			logrus.Infof("Going to clean machine folder of %s", machine.Name)
-			c.cleanDockerMachineFolder(machineDirectory, machine.Name)
+		// folder that wasn't listed was created after listing and belongs
+		// to a new machine
+		if machine := listedMachine(machines, droplet.Name); machine.State != "" {
+			c.cleanDockerMachineFolder(machine)
 		}
-	}
+
+		return result
+	})
 }
 
 func (c *HangingDropletsCleaner) stringInSlice(a string, list []string) bool {
@@ -174,46 +609,118 @@ func (c *HangingDropletsCleaner) stringInSlice(a string, list []string) bool {
 }
 
 func (c *HangingDropletsCleaner) Clean() error {
-	var count int64
+	var results dropletResults
 
 	logrus.Infoln("Starting droplets cleanup")
 	defer func() {
-		logrus.Infof("Finished droplets cleanup. Removed %d droplets", count)
+		results.logSummary()
 	}()
 
 	machines, err := c.machinesFinder.ListMachines(c.runnerPrefixRegexp)
+	if IsMachinesDirectoryError(err) {
+		atomic.AddInt64(&c.totalNumberOfMachinesDirErrors, 1)
+	}
 	if err != nil {
 		return err
 	}
 	logrus.Debugf("Found %d machines matchin prefixes", len(machines))
+	c.countMachines(machines)
 
-	droplets, err := c.client.ListDroplets(c.runnerPrefixRegexp, c.dropletAge)
+	// One listing per pass: both phases work on the same snapshot of the
+	// inventory and the age filter is applied locally
+	dropletsFull, err := c.client.ListInstances(c.runnerPrefixRegexp, 0)
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("Found %d droplets matchin prefixes", len(droplets))
+	logrus.Debugf("Found %d droplets matchin prefixes", len(dropletsFull))
+	c.reportProtectedDroplets(dropletsFull)
+	c.expireSnapshots()
+
+	droplets := c.selectOldDroplets(dropletsFull)
+	logrus.Debugf("Found %d droplets older than %s", len(droplets), c.dropletAge)
+
+	// the other phases work on the full listing, so they run even if no
+	// droplet is old enough
+	if len(droplets) > 0 {
+		results = c.findAndDeleteHangingDroplets(droplets, len(dropletsFull), machines)
+	}
+	c.verifyDeletions()
+
+	logrus.Infoln("Cleaning up Zombie folders")
+	c.findAndDeleteZombieFolders(dropletsFull, machines)
+
+	return nil
+}
+
+func (c *HangingDropletsCleaner) EnableDelete() {
+	c.delete = true
+}
+
+type SupersededPolicy string
+
+const (
+	// SupersededPolicyDelete handles superseded droplets like the hanging
+	// ones (except that the machine folder is left untouched)
+	SupersededPolicyDelete SupersededPolicy = "delete"
+	// SupersededPolicyKeep only reports superseded droplets
+	SupersededPolicyKeep SupersededPolicy = "keep"
+)
+
+func (c *HangingDropletsCleaner) SetSupersededPolicy(policy string) error {
+	switch SupersededPolicy(policy) {
+	case SupersededPolicyDelete, SupersededPolicyKeep:
+		c.supersededPolicy = SupersededPolicy(policy)
+		return nil
+	}
 
-	if len(droplets) < 1 {
+	return fmt.Errorf("Unknown superseded droplets policy '%s'", policy)
+}
+
+func (c *HangingDropletsCleaner) SetStopMode(mode string) error {
+	switch StopMode(mode) {
+	case StopModePowerOff, StopModeSkip:
+		c.stopMode = StopMode(mode)
 		return nil
 	}
 
-	count = c.findAndDeleteHangingDroplets(droplets, machines, c.machinesFinder.GetMachinesDirectory())
+	return fmt.Errorf("Unknown stop mode '%s'", mode)
+}
 
-	logrus.Infoln("Cleaning up Zombie folders")
-	dropletsFull, err := c.client.ListDroplets(c.runnerPrefixRegexp, 0)
+// SetRequiredTags makes the cleaner delete only droplets having all of the
+// given tags
+func (c *HangingDropletsCleaner) SetRequiredTags(tags []string) {
+	c.requiredTags = tags
+}
+
+// EnableCandidateTracking makes the cleaner delete only droplets found
+// hanging in the given number of consecutive passes and for at least
+// minDuration. If stateFile is not empty, the observations are stored there
+func (c *HangingDropletsCleaner) EnableCandidateTracking(observations int, minDuration time.Duration, stateFile string) error {
+	tracker, err := newCandidateTracker(stateFile, observations, minDuration)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error while loading hanging droplets candidates from %s: %v", stateFile, err)
 	}
-	c.findAndDeleteZombieFolders(dropletsFull, machines, c.machinesFinder.GetMachinesDirectory())
+
+	c.candidates = tracker
 
 	return nil
 }
 
-func (c *HangingDropletsCleaner) EnableDelete() {
-	c.delete = true
+// SetMachineStore replaces the store used to remove machines, which by
+// default is the Docker Machine store in the machines directory
+func (c *HangingDropletsCleaner) SetMachineStore(store MachineStoreInterface) {
+	c.machineStore = store
+}
+
+func (c *HangingDropletsCleaner) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	c.concurrency = concurrency
 }
 
-func NewHangingDropletsCleaner(client client.DigitalOceanClientInterface, machinesFinder MachinesFinderInterface, dropletAge int, runnerPrefix []string) (*HangingDropletsCleaner, error) {
+func NewHangingDropletsCleaner(client client.CloudProvider, machinesFinder MachinesFinderInterface, dropletAge int, runnerPrefix []string) (*HangingDropletsCleaner, error) {
 	if len(runnerPrefix) < 1 {
 		return nil, fmt.Errorf("You need to set at least one 'runner-prefix'")
 	}
@@ -229,9 +736,19 @@ func NewHangingDropletsCleaner(client client.DigitalOceanClientInterface, machin
 	cleaner := &HangingDropletsCleaner{
 		client:             client,
 		machinesFinder:     machinesFinder,
+		machineStore:       NewDockerMachineStore(machinesFinder.GetMachinesDirectory(), client),
 		runnerPrefix:       runnerPrefix,
 		runnerPrefixRegexp: re,
 		dropletAge:         da,
+		concurrency:        1,
+		stopMode:           StopModePowerOff,
+		policy:             PolicyDelete,
+		supersededPolicy:   SupersededPolicyDelete,
+		quarantineHold:     DefaultQuarantineHold,
+		snapshotRetention:  DefaultSnapshotRetention,
+
+		zombieFolderMinAge:           DefaultZombieFolderMinAge,
+		zombieFolderArchiveRetention: DefaultZombieFolderArchiveRetention,
 	}
 
 	return cleaner, err
@@ -2,42 +2,119 @@ package cleaner
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/digitalocean/godo"
 	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
 )
 
 type FakeDOClient struct {
 	t                    *testing.T
-	listDropletsAsserts  func(*FakeDOClient) ([]godo.Droplet, error)
-	stopDropletAsserts   func(*FakeDOClient, godo.Droplet) error
-	deleteDropletAsserts func(*FakeDOClient, godo.Droplet) error
+	listDropletsAsserts  func(*FakeDOClient) ([]client.Instance, error)
+	stopDropletAsserts   func(*FakeDOClient, client.Instance) error
+	deleteDropletAsserts func(*FakeDOClient, client.Instance) error
+	dropletExistsAsserts func(*FakeDOClient, client.Instance) (bool, error)
+	tagDropletAsserts    func(*FakeDOClient, client.Instance, string) error
+	untagDropletAsserts  func(*FakeDOClient, client.Instance, string) error
+
+	snapshotDropletAsserts func(*FakeDOClient, client.Instance, string) (string, error)
+	snapshots              []client.Snapshot
+	deletedSnapshots       []client.Snapshot
+
+	removeSSHKeyError error
+	removedSSHKeys    []string
 }
 
-func (fc *FakeDOClient) ListDroplets(dropletsPrefixRegexp *regexp.Regexp, dropletAge time.Duration) ([]godo.Droplet, error) {
+func (fc *FakeDOClient) Name() string {
+	return "fake"
+}
+
+func (fc *FakeDOClient) ListInstances(dropletsPrefixRegexp *regexp.Regexp, dropletAge time.Duration) ([]client.Instance, error) {
 	if fc.listDropletsAsserts != nil {
 		return fc.listDropletsAsserts(fc)
 	}
-	return []godo.Droplet{}, nil
+	return []client.Instance{}, nil
 }
 
-func (fc *FakeDOClient) StopDroplet(droplet godo.Droplet) error {
+func (fc *FakeDOClient) StopInstance(droplet client.Instance) error {
 	if fc.stopDropletAsserts != nil {
 		return fc.stopDropletAsserts(fc, droplet)
 	}
 	return nil
 }
 
-func (fc *FakeDOClient) DeleteDroplet(droplet godo.Droplet) error {
+func (fc *FakeDOClient) DeleteInstance(droplet client.Instance) error {
 	if fc.deleteDropletAsserts != nil {
 		return fc.deleteDropletAsserts(fc, droplet)
 	}
 	return nil
 }
 
+func (fc *FakeDOClient) InstanceExists(droplet client.Instance) (bool, error) {
+	if fc.dropletExistsAsserts != nil {
+		return fc.dropletExistsAsserts(fc, droplet)
+	}
+	return false, nil
+}
+
+func (fc *FakeDOClient) TagInstance(droplet client.Instance, tag string) error {
+	if fc.tagDropletAsserts != nil {
+		return fc.tagDropletAsserts(fc, droplet, tag)
+	}
+	return nil
+}
+
+func (fc *FakeDOClient) UntagInstance(droplet client.Instance, tag string) error {
+	if fc.untagDropletAsserts != nil {
+		return fc.untagDropletAsserts(fc, droplet, tag)
+	}
+	return nil
+}
+
+func (fc *FakeDOClient) SnapshotInstance(droplet client.Instance, name string) (string, error) {
+	if fc.snapshotDropletAsserts != nil {
+		return fc.snapshotDropletAsserts(fc, droplet, name)
+	}
+	return "", nil
+}
+
+func (fc *FakeDOClient) ListSnapshots(namePrefix string) ([]client.Snapshot, error) {
+	return fc.snapshots, nil
+}
+
+func (fc *FakeDOClient) DeleteSnapshot(snapshot client.Snapshot) error {
+	fc.deletedSnapshots = append(fc.deletedSnapshots, snapshot)
+	return nil
+}
+
+func (fc *FakeDOClient) RemoveSSHKey(id string) error {
+	fc.removedSSHKeys = append(fc.removedSSHKeys, id)
+	return fc.removeSSHKeyError
+}
+
+type FakeAuditLog struct {
+	entries []AuditEntry
+}
+
+func (l *FakeAuditLog) Record(entry AuditEntry) error {
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+type FakeNotifier struct {
+	notifications []Notification
+}
+
+func (n *FakeNotifier) Notify(notification Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
 type FakeMachinesFinder struct {
 	t                   *testing.T
 	listMachinesAsserts func(*FakeMachinesFinder) ([]Machine, error)
@@ -54,13 +131,13 @@ func (m *FakeMachinesFinder) GetMachinesDirectory() string {
 	return "/root/.docker/machine/machines"
 }
 
-func getCleaner(t *testing.T) (cleaner *HangingDropletsCleaner, client *FakeDOClient, machinesFinder *FakeMachinesFinder) {
-	client = &FakeDOClient{t: t}
+func getCleaner(t *testing.T) (cleaner *HangingDropletsCleaner, doClient *FakeDOClient, machinesFinder *FakeMachinesFinder) {
+	doClient = &FakeDOClient{t: t}
 	machinesFinder = &FakeMachinesFinder{t: t}
 
 	var err error
 	cleaner, err = NewHangingDropletsCleaner(
-		client,
+		doClient,
 		machinesFinder,
 		10,
 		[]string{"runner-abc123"},
@@ -71,24 +148,24 @@ func getCleaner(t *testing.T) (cleaner *HangingDropletsCleaner, client *FakeDOCl
 }
 
 func TestCleanerNoMachines(t *testing.T) {
-	cleaner, client, _ := getCleaner(t)
+	cleaner, doClient, _ := getCleaner(t)
 	cleaner.EnableDelete()
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -100,23 +177,23 @@ func TestCleanerNoMachines(t *testing.T) {
 }
 
 func TestCleanerNoMachinesAndDeleteDisabled(t *testing.T) {
-	cleaner, client, _ := getCleaner(t)
+	cleaner, doClient, _ := getCleaner(t)
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -128,27 +205,27 @@ func TestCleanerNoMachinesAndDeleteDisabled(t *testing.T) {
 }
 
 func TestCleanerNoDroplets(t *testing.T) {
-	cleaner, client, machinesFinder := getCleaner(t)
+	cleaner, doClient, machinesFinder := getCleaner(t)
 	cleaner.EnableDelete()
 
 	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) (machines []Machine, err error) {
 		machines = []Machine{
 			{
-				Name:      "runner-abc123-test-1",
-				DropletId: 0,
+				Name:       "runner-abc123-test-1",
+				InstanceID: "1",
 			},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -160,13 +237,13 @@ func TestCleanerNoDroplets(t *testing.T) {
 }
 
 func TestCleanerDropletsAndMachinesNotMatching(t *testing.T) {
-	cleaner, client, machinesFinder := getCleaner(t)
+	cleaner, doClient, machinesFinder := getCleaner(t)
 	cleaner.EnableDelete()
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-2", Created: time.Now().Format(time.RFC3339)},
-			{ID: 2, Name: "runner-abc123-test-3", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-test-3", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
@@ -174,21 +251,21 @@ func TestCleanerDropletsAndMachinesNotMatching(t *testing.T) {
 	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) (machines []Machine, err error) {
 		machines = []Machine{
 			{
-				Name:      "runner-abc123-test-1",
-				DropletId: 0,
+				Name:       "runner-abc123-test-1",
+				InstanceID: "3",
 			},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -201,14 +278,14 @@ func TestCleanerDropletsAndMachinesNotMatching(t *testing.T) {
 }
 
 func TestCleanerDropletsAndMachinesPartiallyMatching(t *testing.T) {
-	cleaner, client, machinesFinder := getCleaner(t)
+	cleaner, doClient, machinesFinder := getCleaner(t)
 	cleaner.EnableDelete()
 
-	dropletToBeRemoved := godo.Droplet{ID: 2, Name: "runner-abc123-test-2", Created: time.Now().Format(time.RFC3339)}
+	dropletToBeRemoved := client.Instance{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-1 * time.Hour)}
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
 			dropletToBeRemoved,
 		}
 		return
@@ -217,22 +294,22 @@ func TestCleanerDropletsAndMachinesPartiallyMatching(t *testing.T) {
 	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) (machines []Machine, err error) {
 		machines = []Machine{
 			{
-				Name:      "runner-abc123-test-1",
-				DropletId: 0,
+				Name:       "runner-abc123-test-1",
+				InstanceID: "1",
 			},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		assert.Equal(t, droplet, dropletToBeRemoved, "Should stop only specified droplet")
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		assert.Equal(t, droplet, dropletToBeRemoved, "Should remove only specified droplet")
 		deleteDropletCalled = true
 		return
@@ -246,13 +323,13 @@ func TestCleanerDropletsAndMachinesPartiallyMatching(t *testing.T) {
 }
 
 func TestCleanerDropletsAndMachinesMatching(t *testing.T) {
-	cleaner, client, machinesFinder := getCleaner(t)
+	cleaner, doClient, machinesFinder := getCleaner(t)
 	cleaner.EnableDelete()
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
-			{ID: 2, Name: "runner-abc123-test-2", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
@@ -260,25 +337,25 @@ func TestCleanerDropletsAndMachinesMatching(t *testing.T) {
 	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) (machines []Machine, err error) {
 		machines = []Machine{
 			{
-				Name:      "runner-abc123-test-1",
-				DropletId: 0,
+				Name:       "runner-abc123-test-1",
+				InstanceID: "1",
 			},
 			{
-				Name:      "runner-abc123-test-2",
-				DropletId: 0,
+				Name:       "runner-abc123-test-2",
+				InstanceID: "2",
 			},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -290,24 +367,24 @@ func TestCleanerDropletsAndMachinesMatching(t *testing.T) {
 }
 
 func TestErrorOnMachineStop(t *testing.T) {
-	cleaner, client, _ := getCleaner(t)
+	cleaner, doClient, _ := getCleaner(t)
 	cleaner.EnableDelete()
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return errors.New("error on machine stop")
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		return
 	}
@@ -322,24 +399,24 @@ func TestErrorOnMachineStop(t *testing.T) {
 }
 
 func TestErrorOnMachineDelete(t *testing.T) {
-	cleaner, client, _ := getCleaner(t)
+	cleaner, doClient, _ := getCleaner(t)
 	cleaner.EnableDelete()
 
-	client.listDropletsAsserts = func(c *FakeDOClient) (droplets []godo.Droplet, err error) {
-		droplets = []godo.Droplet{
-			{ID: 1, Name: "runner-abc123-test-1", Created: time.Now().Format(time.RFC3339)},
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
 		}
 		return
 	}
 
 	stopDropletCalled := false
-	client.stopDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		stopDropletCalled = true
 		return
 	}
 
 	deleteDropletCalled := false
-	client.deleteDropletAsserts = func(c *FakeDOClient, droplet godo.Droplet) (err error) {
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
 		deleteDropletCalled = true
 		err = errors.New("error on machine delete")
 		return
@@ -353,3 +430,757 @@ func TestErrorOnMachineDelete(t *testing.T) {
 	assert.Equal(t, int64(1), cleaner.totalNumberOfRemoveDropletErrors, "Should count delete errors")
 	assert.Equal(t, int64(0), cleaner.totalNumberOfRemovedDroplets, "There should be no deletes")
 }
+
+func TestStopModeSkip(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetStopMode("skip"))
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}
+		return
+	}
+
+	stopDropletCalled := false
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
+		stopDropletCalled = true
+		return
+	}
+
+	deleteDropletCalled := false
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
+		deleteDropletCalled = true
+		return
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.False(t, stopDropletCalled, "StopDroplet() should not be called")
+	assert.True(t, deleteDropletCalled, "DeleteDroplet() should be called")
+	assert.Error(t, cleaner.SetStopMode("unknown"))
+}
+
+func TestTimeoutOnMachineStop(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}
+		return
+	}
+
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
+		return &client.ActionError{Action: "power_off", InstanceID: droplet.ID, Status: "in-progress", Timeout: true}
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), cleaner.totalNumberOfStopDropletErrors, "Should count stop errors")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfStopDropletTimeouts, "Should count stop timeouts")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfRemovedDroplets, "Should remove droplet after stop timeout")
+}
+
+func TestPhantomDeleteIsRetried(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.EnableDeleteVerification(0, 3)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}
+		return
+	}
+
+	deleteDropletCalls := 0
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
+		deleteDropletCalls++
+		return
+	}
+
+	doClient.dropletExistsAsserts = func(c *FakeDOClient, droplet client.Instance) (bool, error) {
+		return deleteDropletCalls < 2, nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	cleaner.FinishDeletionVerifications()
+	assert.Equal(t, 2, deleteDropletCalls, "Deletion should be retried once")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfPhantomDeletes, "Should count phantom deletes")
+	assert.Equal(t, int64(0), cleaner.totalNumberOfUndeletableDroplets, "There should be no undeletable droplets")
+}
+
+func TestUndeletableDropletIsEscalated(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.EnableDeleteVerification(0, 2)
+
+	notifier := &FakeNotifier{}
+	cleaner.SetNotifier(notifier)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}
+		return
+	}
+
+	deleteDropletCalls := 0
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) (err error) {
+		deleteDropletCalls++
+		return
+	}
+
+	doClient.dropletExistsAsserts = func(c *FakeDOClient, droplet client.Instance) (bool, error) {
+		return true, nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	cleaner.FinishDeletionVerifications()
+	assert.Equal(t, 2, deleteDropletCalls, "Deletion should be retried until the limit is reached")
+	assert.Equal(t, int64(2), cleaner.totalNumberOfPhantomDeletes, "Should count phantom deletes")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfUndeletableDroplets, "Should count undeletable droplets")
+	if assert.Len(t, notifier.notifications, 1) {
+		assert.Equal(t, EventUndeletableDroplet, notifier.notifications[0].Event)
+		assert.Equal(t, "1", notifier.notifications[0].Droplet.ID)
+	}
+}
+
+func TestDeletionIsVerifiedByNextPass(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.EnableDeleteVerification(time.Hour, 3)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+		droplets = []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}
+		return
+	}
+
+	dropletExistsCalls := 0
+	doClient.dropletExistsAsserts = func(c *FakeDOClient, droplet client.Instance) (bool, error) {
+		dropletExistsCalls++
+		return false, nil
+	}
+
+	start := time.Now()
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < time.Minute, "Cleanup shouldn't wait for the verification")
+	assert.Equal(t, 0, dropletExistsCalls, "Deletion shouldn't be verified before the delay")
+	if !assert.Len(t, cleaner.pendingVerifications, 1) {
+		return
+	}
+
+	cleaner.pendingVerifications[0].dueAt = time.Now()
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return nil, nil
+	}
+
+	err = cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropletExistsCalls, "Deletion should be verified by the next pass after the delay")
+	assert.Empty(t, cleaner.pendingVerifications)
+}
+
+func TestDropletsAreDeletedConcurrently(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.SetConcurrency(3)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		var droplets []client.Instance
+		for i := 0; i < 9; i++ {
+			droplets = append(droplets, client.Instance{ID: fmt.Sprintf("%d", i), Name: fmt.Sprintf("runner-abc123-test-%d", i), CreatedAt: time.Now().Add(-1 * time.Hour)})
+		}
+		return droplets, nil
+	}
+
+	lock := new(sync.Mutex)
+	running, maxRunning := 0, 0
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		return nil
+	}
+
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		if droplet.ID == "4" {
+			return errors.New("test error")
+		}
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, maxRunning, "Should stop droplets using all workers")
+	assert.Equal(t, int64(8), cleaner.totalNumberOfRemovedDroplets, "Should count removed droplets from all workers")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfRemoveDropletErrors, "Should count delete errors from all workers")
+}
+
+func TestDropletResultsAggregation(t *testing.T) {
+	results := dropletResults{
+		{droplet: client.Instance{Name: "a"}, deleted: true},
+		{droplet: client.Instance{Name: "b"}, deleted: true, stopErr: errors.New("stop")},
+		{droplet: client.Instance{Name: "c"}, stopErr: errors.New("stop"), deleteErr: errors.New("delete")},
+	}
+
+	assert.Equal(t, 2, results.deleted())
+	assert.Equal(t, []string{"b", "c"}, results.failedNames(func(r dropletResult) bool { return r.stopErr != nil }))
+	assert.Equal(t, []string{"c"}, results.failedNames(func(r dropletResult) bool { return r.deleteErr != nil }))
+}
+
+func TestDropletsAreListedOncePerPass(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+
+	listings := 0
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		listings++
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now()},
+			{ID: "3", Name: "runner-abc123-test-3"},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{
+			{Name: "runner-abc123-test-1", InstanceID: "1"},
+		}, nil
+	}
+
+	var deleted []string
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, listings, "Should list droplets only once")
+	assert.Empty(t, deleted, "Should not delete droplets younger than droplet-age or without creation time")
+}
+
+func TestRequiredTags(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.SetRequiredTags([]string{"runners", "ci"})
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour), Tags: []string{"ci", "runners"}},
+			{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-1 * time.Hour), Tags: []string{"runners"}},
+			{ID: "3", Name: "runner-abc123-test-3", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	var deleted []string
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-test-1"}, deleted, "Should delete only droplets having all required tags")
+}
+
+func TestProtectedDropletsAreNotDeleted(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.SetProtection([]string{"keep", "debug-hold"}, []string{"runner-abc123-test-2", "3"})
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour), Tags: []string{"debug-hold"}},
+			{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "3", Name: "runner-abc123-test-3", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "4", Name: "runner-abc123-test-4", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	var stopped, deleted []string
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		stopped = append(stopped, droplet.Name)
+		return nil
+	}
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-test-4"}, stopped, "Should not stop protected droplets")
+	assert.Equal(t, []string{"runner-abc123-test-4"}, deleted, "Should not delete protected droplets")
+	assert.Equal(t, int64(3), cleaner.numberOfProtectedDroplets, "Should count protected droplets")
+}
+
+func TestHangingDropletIsDeletedAfterConfirmations(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.EnableCandidateTracking(3, 0, ""))
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	deletes := 0
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deletes++
+		return nil
+	}
+
+	for pass := 1; pass <= 3; pass++ {
+		assert.NoError(t, cleaner.Clean())
+		if pass < 3 {
+			assert.Equal(t, 0, deletes, "Should not delete droplet in pass %d", pass)
+		}
+	}
+	assert.Equal(t, 1, deletes, "Should delete droplet in third pass")
+}
+
+func TestQuarantinePolicy(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetPolicy(string(PolicyQuarantine), time.Hour))
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-3 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-test-2", CreatedAt: time.Now().Add(-3 * time.Hour), Tags: []string{quarantineTag(time.Now().Add(-10 * time.Minute))}},
+			{ID: "3", Name: "runner-abc123-test-3", CreatedAt: time.Now().Add(-3 * time.Hour), Tags: []string{quarantineTag(time.Now().Add(-2 * time.Hour))}},
+		}, nil
+	}
+
+	var stopped, deleted []string
+	tagged := make(map[string]string)
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		stopped = append(stopped, droplet.Name)
+		return nil
+	}
+	doClient.tagDropletAsserts = func(c *FakeDOClient, droplet client.Instance, tag string) error {
+		tagged[droplet.Name] = tag
+		return nil
+	}
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-test-1"}, stopped, "Should stop only droplets entering the quarantine")
+	assert.Len(t, tagged, 1)
+	assert.Contains(t, tagged["runner-abc123-test-1"], QuarantineTagPrefix)
+	assert.Equal(t, []string{"runner-abc123-test-3"}, deleted, "Should delete only droplets quarantined for longer than the hold")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfQuarantinedDroplets)
+}
+
+func TestQuarantineSkipsDropletThatWasNotStopped(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetPolicy(string(PolicyQuarantine), time.Hour))
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-3 * time.Hour)},
+		}, nil
+	}
+	doClient.stopDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		return errors.New("stop failed")
+	}
+	doClient.tagDropletAsserts = func(c *FakeDOClient, droplet client.Instance, tag string) error {
+		assert.Fail(t, "Droplet that wasn't stopped shouldn't be quarantined")
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), cleaner.totalNumberOfQuarantinedDroplets)
+}
+
+func TestQuarantineIsReleasedWhenDropletHasMachine(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetPolicy(string(PolicyQuarantine), time.Hour))
+
+	tag := quarantineTag(time.Now().Add(-2 * time.Hour))
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-3 * time.Hour), Tags: []string{"keep", tag}},
+		}, nil
+	}
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{
+			{Name: "runner-abc123-test-1", InstanceID: "1", State: MachineStateComplete},
+		}, nil
+	}
+
+	untagged := make(map[string]string)
+	doClient.untagDropletAsserts = func(c *FakeDOClient, droplet client.Instance, tag string) error {
+		untagged[droplet.Name] = tag
+		return nil
+	}
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		assert.Fail(t, "Droplet that has a machine shouldn't be deleted")
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"runner-abc123-test-1": tag}, untagged, "Should remove the quarantine tag")
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	cleaner, _, _ := getCleaner(t)
+	assert.Error(t, cleaner.SetPolicy("unknown", time.Hour))
+}
+
+func TestSnapshotBeforeDelete(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetSnapshotPolicy([]string{"runner-abc123-forensic"}, time.Hour))
+
+	auditLog := new(FakeAuditLog)
+	cleaner.SetAuditLog(auditLog)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-forensic-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-forensic-2", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "3", Name: "runner-abc123-test-3", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	var snapshotted []string
+	doClient.snapshotDropletAsserts = func(c *FakeDOClient, droplet client.Instance, name string) (string, error) {
+		snapshotted = append(snapshotted, droplet.Name)
+		assert.Contains(t, name, SnapshotNamePrefix+droplet.Name)
+		if droplet.ID == "2" {
+			return "", errors.New("test error")
+		}
+		return "100", nil
+	}
+
+	var deleted []string
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-forensic-1", "runner-abc123-forensic-2"}, snapshotted, "Should snapshot only droplets matching the snapshot prefix")
+	assert.Equal(t, []string{"runner-abc123-forensic-1", "runner-abc123-test-3"}, deleted, "Should not delete droplet when snapshot fails")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfSnapshots)
+	assert.Equal(t, int64(1), cleaner.totalNumberOfSnapshotErrors)
+
+	if assert.Len(t, auditLog.entries, 3) {
+		assert.Equal(t, AuditEventDropletSnapshotted, auditLog.entries[0].Event)
+		assert.Equal(t, "100", auditLog.entries[0].Snapshot.ID)
+		assert.Equal(t, AuditEventDropletDeleted, auditLog.entries[1].Event)
+	}
+}
+
+func TestExpiredSnapshotsAreRemoved(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetSnapshotPolicy([]string{"runner-abc123"}, time.Hour))
+
+	doClient.snapshots = []client.Snapshot{
+		{ID: "1", Name: SnapshotNamePrefix + "runner-abc123-1", CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{ID: "2", Name: SnapshotNamePrefix + "runner-abc123-2", CreatedAt: time.Now().Add(-10 * time.Minute)},
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	if assert.Len(t, doClient.deletedSnapshots, 1) {
+		assert.Equal(t, "1", doClient.deletedSnapshots[0].ID, "Should remove only snapshots older than retention")
+	}
+	assert.Equal(t, int64(1), cleaner.totalNumberOfExpiredSnapshots)
+}
+
+func TestExpiredSnapshotsAreRemovedWithoutPrefixes(t *testing.T) {
+	cleaner, doClient, _ := getCleaner(t)
+	cleaner.EnableDelete()
+	assert.NoError(t, cleaner.SetSnapshotPolicy(nil, time.Hour))
+
+	doClient.snapshots = []client.Snapshot{
+		{ID: "1", Name: SnapshotNamePrefix + "runner-other-1", CreatedAt: time.Now().Add(-2 * time.Hour)},
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	if assert.Len(t, doClient.deletedSnapshots, 1, "Retention should apply to all snapshots taken by the cleaner") {
+		assert.Equal(t, "1", doClient.deletedSnapshots[0].ID)
+	}
+}
+
+func TestDeletionLimits(t *testing.T) {
+	examples := map[string]struct {
+		maxDeletions        int
+		maxDeletionsPercent float64
+		override            bool
+		youngDroplets       int
+		expectedDeletes     int
+	}{
+		"no limits":               {expectedDeletes: 3},
+		"default limits":          {maxDeletions: DefaultMaxDeletions, maxDeletionsPercent: DefaultMaxDeletionsPercent, expectedDeletes: 0},
+		"young droplets counted":  {maxDeletionsPercent: 50, youngDroplets: 4, expectedDeletes: 3},
+		"below absolute limit":    {maxDeletions: 3, expectedDeletes: 3},
+		"above absolute limit":    {maxDeletions: 2, expectedDeletes: 0},
+		"below percentage limit":  {maxDeletionsPercent: 75, expectedDeletes: 3},
+		"above percentage limit":  {maxDeletionsPercent: 50, expectedDeletes: 0},
+		"overridden limit":        {maxDeletions: 2, override: true, expectedDeletes: 3},
+		"both limits, one exceed": {maxDeletions: 10, maxDeletionsPercent: 50, expectedDeletes: 0},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			cleaner, doClient, machinesFinder := getCleaner(t)
+			cleaner.EnableDelete()
+			cleaner.SetDeletionLimits(example.maxDeletions, example.maxDeletionsPercent, example.override)
+
+			notifier := new(FakeNotifier)
+			cleaner.SetNotifier(notifier)
+
+			doClient.listDropletsAsserts = func(c *FakeDOClient) (droplets []client.Instance, err error) {
+				for i := 1; i <= 4; i++ {
+					droplets = append(droplets, client.Instance{ID: fmt.Sprintf("%d", i), Name: fmt.Sprintf("runner-abc123-test-%d", i), CreatedAt: time.Now().Add(-1 * time.Hour)})
+				}
+				for i := 1; i <= example.youngDroplets; i++ {
+					droplets = append(droplets, client.Instance{ID: fmt.Sprintf("young-%d", i), Name: fmt.Sprintf("runner-abc123-young-%d", i), CreatedAt: time.Now()})
+				}
+				return
+			}
+
+			machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+				return []Machine{{Name: "runner-abc123-test-1", InstanceID: "1"}}, nil
+			}
+
+			deletes := 0
+			doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+				deletes++
+				return nil
+			}
+
+			err := cleaner.Clean()
+			assert.NoError(t, err)
+			assert.Equal(t, example.expectedDeletes, deletes)
+
+			if example.expectedDeletes == 0 {
+				assert.Equal(t, int64(1), cleaner.deletionLimitExceeded)
+				assert.Equal(t, int64(1), cleaner.totalNumberOfDeletionLimitTrips)
+				if assert.Len(t, notifier.notifications, 1) {
+					assert.Equal(t, EventDeletionLimitExceeded, notifier.notifications[0].Event)
+				}
+			} else {
+				assert.Equal(t, int64(0), cleaner.deletionLimitExceeded)
+				assert.Empty(t, notifier.notifications)
+			}
+		})
+	}
+}
+
+func TestMachinesDirectoryErrorAbortsCleanup(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return nil, &MachinesDirectoryError{Directory: "/machines", Reason: "empty"}
+	}
+
+	listDropletsCalled := false
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		listDropletsCalled = true
+		return nil, nil
+	}
+
+	err := cleaner.Clean()
+	assert.True(t, IsMachinesDirectoryError(err))
+	assert.False(t, listDropletsCalled, "Should not list droplets")
+	assert.Equal(t, int64(1), cleaner.totalNumberOfMachinesDirErrors)
+}
+
+func TestDropletsOfIncompleteMachinesAreKept(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-creating", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-corrupt", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "3", Name: "runner-abc123-hanging", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{
+			{Name: "runner-abc123-creating", State: MachineStateCreating},
+			{Name: "runner-abc123-corrupt", State: MachineStateCorrupt},
+		}, nil
+	}
+
+	var deleted []string
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-hanging"}, deleted, "Should keep droplets of machines being created or corrupted")
+	assert.Equal(t, 1, cleaner.machinesByState[MachineStateCreating])
+	assert.Equal(t, 1, cleaner.machinesByState[MachineStateCorrupt])
+}
+
+func TestDropletsAreMatchedToMachinesByID(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-renamed", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-hanging", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{
+			{Name: "runner-abc123-test-1", InstanceID: "1", State: MachineStateComplete},
+		}, nil
+	}
+
+	var deleted []string
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		deleted = append(deleted, droplet.Name)
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123-hanging"}, deleted, "Should keep droplet matching machine by ID")
+}
+
+func TestSupersededDroplets(t *testing.T) {
+	for _, policy := range []SupersededPolicy{SupersededPolicyDelete, SupersededPolicyKeep} {
+		t.Run(string(policy), func(t *testing.T) {
+			cleaner, doClient, machinesFinder := getCleaner(t)
+			cleaner.EnableDelete()
+			assert.NoError(t, cleaner.SetSupersededPolicy(string(policy)))
+
+			doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+				return []client.Instance{
+					{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-2 * time.Hour), Provider: client.DigitalOceanProviderName},
+					{ID: "2", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour), Provider: client.DigitalOceanProviderName},
+				}, nil
+			}
+
+			machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+				return []Machine{
+					{Name: "runner-abc123-test-1", Driver: "digitalocean", InstanceID: "2", State: MachineStateComplete},
+				}, nil
+			}
+
+			var deleted []string
+			doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+				deleted = append(deleted, droplet.ID)
+				return nil
+			}
+
+			err := cleaner.Clean()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), cleaner.numberOfSupersededDroplets)
+
+			if policy == SupersededPolicyDelete {
+				assert.Equal(t, []string{"1"}, deleted, "Should delete only the superseded droplet")
+			} else {
+				assert.Empty(t, deleted, "Should not delete superseded droplet")
+			}
+		})
+	}
+}
+
+func TestSupersededGoogleInstancesAreSkipped(t *testing.T) {
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: client.GoogleInstanceID("us-east1-b", "runner-abc123-test-1"), Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-2 * time.Hour), Provider: client.GoogleProviderName},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{
+			{Name: "runner-abc123-test-1", Driver: "google", InstanceID: client.GoogleInstanceID("us-east1-c", "runner-abc123-test-1"), State: MachineStateComplete},
+		}, nil
+	}
+
+	doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+		assert.Fail(t, "Google instance with a name of an existing machine shouldn't be deleted")
+		return nil
+	}
+
+	err := cleaner.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), cleaner.numberOfSupersededDroplets)
+}
+
+func TestDropletsWithNameOfMachineWithoutUsableIDAreSkipped(t *testing.T) {
+	examples := map[string]Machine{
+		"machine of another driver": {Name: "runner-abc123-test-1", Driver: "amazonec2", InstanceID: "i-0123456789", State: MachineStateComplete},
+		"machine of unknown driver": {Name: "runner-abc123-test-1", Driver: "virtualbox", State: MachineStateComplete},
+	}
+
+	for name, machine := range examples {
+		t.Run(name, func(t *testing.T) {
+			cleaner, doClient, machinesFinder := getCleaner(t)
+			cleaner.EnableDelete()
+
+			doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+				return []client.Instance{
+					{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-2 * time.Hour), Provider: client.DigitalOceanProviderName},
+				}, nil
+			}
+
+			machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+				return []Machine{machine}, nil
+			}
+
+			doClient.deleteDropletAsserts = func(c *FakeDOClient, droplet client.Instance) error {
+				assert.Fail(t, "Droplet with a name of an existing machine shouldn't be deleted")
+				return nil
+			}
+
+			assert.NoError(t, cleaner.Clean())
+			assert.Equal(t, int64(0), cleaner.numberOfSupersededDroplets)
+		})
+	}
+}
+
+func TestUnknownSupersededPolicy(t *testing.T) {
+	cleaner, _, _ := getCleaner(t)
+	assert.Error(t, cleaner.SetSupersededPolicy("unknown"))
+}
@@ -0,0 +1,74 @@
+package cleaner
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultMaxDeletions        = 50
+	DefaultMaxDeletionsPercent = 50
+)
+
+// SetDeletionLimits configures the circuit breaker: if more than maxDeletions
+// droplets, or more than maxPercent of all droplets matching prefixes
+// (regardless of their age), are found hanging in one pass, then none of them
+// is deleted unless override is set. Zero disables the given limit
+func (c *HangingDropletsCleaner) SetDeletionLimits(maxDeletions int, maxPercent float64, override bool) {
+	c.maxDeletions = maxDeletions
+	c.maxDeletionsPercent = maxPercent
+	c.overrideDeletionLimits = override
+}
+
+func (c *HangingDropletsCleaner) deletionLimitError(hanging int, matched int) error {
+	if c.maxDeletions > 0 && hanging > c.maxDeletions {
+		return fmt.Errorf("%d droplets found hanging, which is more than the limit of %d", hanging, c.maxDeletions)
+	}
+
+	if c.maxDeletionsPercent > 0 && matched > 0 {
+		percent := float64(hanging) / float64(matched) * 100
+		if percent > c.maxDeletionsPercent {
+			return fmt.Errorf("%d of %d droplets (%.1f%%) found hanging, which is more than the limit of %.1f%%", hanging, matched, percent, c.maxDeletionsPercent)
+		}
+	}
+
+	return nil
+}
+
+// checkDeletionLimits returns false when the deletion of hanging droplets
+// should be refused
+func (c *HangingDropletsCleaner) checkDeletionLimits(hanging int, matched int) bool {
+	err := c.deletionLimitError(hanging, matched)
+	if err == nil {
+		atomic.StoreInt64(&c.deletionLimitExceeded, 0)
+		return true
+	}
+
+	if c.overrideDeletionLimits {
+		logrus.Warnf("Deletion limit exceeded: %v. Proceeding because the limit is overridden", err)
+		atomic.StoreInt64(&c.deletionLimitExceeded, 0)
+		return true
+	}
+
+	atomic.StoreInt64(&c.deletionLimitExceeded, 1)
+	atomic.AddInt64(&c.totalNumberOfDeletionLimitTrips, 1)
+
+	message := fmt.Sprintf("Deletion limit exceeded: %v. Refusing to delete droplets; check the machines directory or use the override to proceed", err)
+	logrus.Errorln(message)
+
+	if c.notifier != nil {
+		err := c.notifier.Notify(Notification{
+			Event:   EventDeletionLimitExceeded,
+			Message: message,
+			Time:    time.Now(),
+		})
+		if err != nil {
+			logrus.Errorf("Error while sending notification about exceeded deletion limit: %v", err.Error())
+		}
+	}
+
+	return false
+}
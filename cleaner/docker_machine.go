@@ -0,0 +1,227 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+// dockerMachineHost is the part of Docker Machine's host file (config.json)
+// that is used by the cleaner
+type dockerMachineHost struct {
+	ConfigVersion int
+	Name          string
+	DriverName    string
+	Driver        json.RawMessage
+	HostOptions   *dockerMachineHostOptions
+}
+
+type dockerMachineHostOptions struct {
+	Driver      string
+	Memory      int
+	Disk        int
+	AuthOptions struct {
+		StorePath string
+	}
+}
+
+// dockerMachineBaseDriver holds the fields that Docker Machine stores for
+// every driver
+type dockerMachineBaseDriver struct {
+	IPAddress   string
+	MachineName string
+	SSHUser     string
+	SSHPort     int
+	StorePath   string
+}
+
+// driverDetails are the cloud instance details read from driver's
+// configuration; empty InstanceID means that the instance was not created yet
+type driverDetails struct {
+	InstanceID string
+	Region     string
+	Size       string
+	Zone       string
+	Project    string
+
+	SSHKeyID          string
+	SSHKeyFingerprint string
+}
+
+type driverDetailsReader func(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error)
+
+func numericID(id int) string {
+	if id == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(id)
+}
+
+func digitalOceanDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		DropletID         int
+		SSHKeyID          int
+		SSHKeyFingerprint string
+		Region            string
+		Size              string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{
+		InstanceID:        numericID(driver.DropletID),
+		Region:            driver.Region,
+		Size:              driver.Size,
+		SSHKeyID:          numericID(driver.SSHKeyID),
+		SSHKeyFingerprint: driver.SSHKeyFingerprint,
+	}, err
+}
+
+func amazonEC2DriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		InstanceID   string `json:"InstanceId"`
+		Region       string
+		Zone         string
+		InstanceType string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{InstanceID: driver.InstanceID, Region: driver.Region, Zone: driver.Zone, Size: driver.InstanceType}, err
+}
+
+func googleDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		Zone        string
+		MachineType string
+		Project     string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	details := driverDetails{Zone: driver.Zone, Size: driver.MachineType, Project: driver.Project}
+
+	name := base.MachineName
+	if name == "" {
+		name = machineName
+	}
+
+	// Google instances are identified by the zone and the name, there is
+	// no ID stored by the driver
+	if driver.Zone != "" {
+		details.InstanceID = client.GoogleInstanceID(driver.Zone, name)
+	}
+
+	return details, err
+}
+
+func hetznerDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		ServerID       int
+		ServerLocation string
+		ServerType     string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{InstanceID: numericID(driver.ServerID), Region: driver.ServerLocation, Size: driver.ServerType}, err
+}
+
+func linodeDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		InstanceID   int
+		Region       string
+		InstanceType string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{InstanceID: numericID(driver.InstanceID), Region: driver.Region, Size: driver.InstanceType}, err
+}
+
+func openStackDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		MachineID  string `json:"MachineId"`
+		Region     string
+		FlavorName string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{InstanceID: driver.MachineID, Region: driver.Region, Size: driver.FlavorName}, err
+}
+
+func vultrDriverDetails(data []byte, base dockerMachineBaseDriver, machineName string) (driverDetails, error) {
+	var driver struct {
+		MachineID string
+		Region    string
+		Plan      string
+	}
+	err := json.Unmarshal(data, &driver)
+
+	return driverDetails{InstanceID: driver.MachineID, Region: driver.Region, Size: driver.Plan}, err
+}
+
+// driverDetailsReaders maps Docker Machine driver name to the function that
+// reads cloud instance details from driver's configuration
+var driverDetailsReaders = map[string]driverDetailsReader{
+	"digitalocean": digitalOceanDriverDetails,
+	"amazonec2":    amazonEC2DriverDetails,
+	"google":       googleDriverDetails,
+	"hetzner":      hetznerDriverDetails,
+	"linode":       linodeDriverDetails,
+	"openstack":    openStackDriverDetails,
+	"vultr":        vultrDriverDetails,
+}
+
+// Machines created before DriverName was recorded are all DigitalOcean ones
+const defaultDriverName = "digitalocean"
+
+func parseDockerMachineHost(data []byte) (*dockerMachineHost, error) {
+	var host dockerMachineHost
+	err := json.Unmarshal(data, &host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(host.Driver) == 0 || string(host.Driver) == "null" {
+		return nil, fmt.Errorf("missing Driver section")
+	}
+
+	return &host, nil
+}
+
+// updateMachine fills machine details from the host file. known is false if
+// the driver is not supported by the cleaner
+func (h *dockerMachineHost) updateMachine(machine *Machine) (known bool, err error) {
+	machine.Driver = h.DriverName
+
+	var base dockerMachineBaseDriver
+	err = json.Unmarshal(h.Driver, &base)
+	if err != nil {
+		return false, err
+	}
+	machine.IPAddress = base.IPAddress
+
+	driverName := h.DriverName
+	if driverName == "" {
+		driverName = defaultDriverName
+	}
+
+	reader, known := driverDetailsReaders[driverName]
+	if !known {
+		return false, nil
+	}
+
+	details, err := reader(h.Driver, base, machine.Name)
+	if err != nil {
+		return true, fmt.Errorf("invalid %s driver configuration: %v", driverName, err)
+	}
+
+	machine.InstanceID = details.InstanceID
+	machine.Region = details.Region
+	machine.Size = details.Size
+	machine.Zone = details.Zone
+	machine.Project = details.Project
+	machine.SSHKeyID = details.SSHKeyID
+	machine.SSHKeyFingerprint = details.SSHKeyFingerprint
+
+	return true, nil
+}
@@ -0,0 +1,180 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const DefaultInventoryTimeout = 30 * time.Second
+
+// InventoryMachine is a machine in the inventory exported by the agent
+type InventoryMachine struct {
+	Name       string       `json:"name"`
+	Driver     string       `json:"driver,omitempty"`
+	InstanceID string       `json:"instance_id,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	State      MachineState `json:"state"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Inventory is the list of machines of one host, exported by the agent and
+// consumed by RemoteMachinesFinder
+type Inventory struct {
+	Host     string             `json:"host"`
+	Machines []InventoryMachine `json:"machines"`
+}
+
+func NewInventoryMachine(machine Machine) InventoryMachine {
+	inventoryMachine := InventoryMachine{
+		Name:       machine.Name,
+		Driver:     machine.Driver,
+		InstanceID: machine.InstanceID,
+		CreatedAt:  machine.CreatedAt,
+		State:      machine.State,
+	}
+
+	if machine.Error != nil {
+		inventoryMachine.Error = machine.Error.Error()
+	}
+
+	return inventoryMachine
+}
+
+func isKnownMachineState(state MachineState) bool {
+	for _, known := range machineStates {
+		if state == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m InventoryMachine) machine(inventory string) Machine {
+	machine := Machine{
+		Name:       m.Name,
+		Driver:     m.Driver,
+		InstanceID: m.InstanceID,
+		CreatedAt:  m.CreatedAt,
+		State:      m.State,
+		Inventory:  inventory,
+	}
+
+	if m.Error != "" {
+		machine.Error = errors.New(m.Error)
+	}
+
+	// machine in a state unknown to this version may own a droplet, so
+	// it's protected like the corrupted ones
+	if !isKnownMachineState(machine.State) {
+		machine = corruptMachine(machine, fmt.Errorf("unknown machine state %q", m.State))
+	}
+
+	return machine
+}
+
+// InventoryError means that a remote inventory couldn't be read; the cleanup
+// must be aborted, as machines of the remote host are unknown
+type InventoryError struct {
+	URL string
+	Err error
+}
+
+func (e *InventoryError) Error() string {
+	return fmt.Sprintf("couldn't list machines from %s: %v", e.URL, e.Err)
+}
+
+func IsInventoryError(err error) bool {
+	_, ok := err.(*InventoryError)
+	return ok
+}
+
+// RemoteMachinesFinder lists machines from the inventory exported by the
+// agent running on another host. The last inventory is kept with its ETag,
+// so it's downloaded again only when it changes
+type RemoteMachinesFinder struct {
+	url    string
+	token  string
+	client *http.Client
+
+	etag      string
+	inventory *Inventory
+}
+
+func (f *RemoteMachinesFinder) fetchInventory() (*Inventory, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	if f.inventory != nil && f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && f.inventory != nil {
+		return f.inventory, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inventory responded with status %d", resp.StatusCode)
+	}
+
+	var inventory Inventory
+	err = json.NewDecoder(resp.Body).Decode(&inventory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory: %v", err)
+	}
+
+	f.inventory = &inventory
+	f.etag = resp.Header.Get("ETag")
+
+	return &inventory, nil
+}
+
+func (f *RemoteMachinesFinder) ListMachines(runnerPrefixRegexp *regexp.Regexp) ([]Machine, error) {
+	inventory, err := f.fetchInventory()
+	if err != nil {
+		return nil, &InventoryError{URL: f.url, Err: err}
+	}
+
+	var machines []Machine
+	for _, inventoryMachine := range inventory.Machines {
+		if !runnerPrefixRegexp.MatchString(inventoryMachine.Name) {
+			continue
+		}
+
+		machines = append(machines, inventoryMachine.machine(f.url))
+	}
+
+	return machines, nil
+}
+
+// GetMachinesDirectory returns empty string, as the machines of a remote
+// inventory are not stored locally
+func (f *RemoteMachinesFinder) GetMachinesDirectory() string {
+	return ""
+}
+
+func NewRemoteMachinesFinder(url string, token string) *RemoteMachinesFinder {
+	return &RemoteMachinesFinder{
+		url:   url,
+		token: token,
+		client: &http.Client{
+			Timeout: DefaultInventoryTimeout,
+		},
+	}
+}
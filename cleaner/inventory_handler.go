@@ -0,0 +1,137 @@
+package cleaner
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var allMachines = regexp.MustCompile("")
+
+// InventoryHandler serves the inventory of local machines as JSON. The
+// inventory is read with Refresh and served with an ETag, so consumers can
+// poll it with If-None-Match cheaply. Requests must be authenticated with
+// the `Authorization: Bearer <token>` header
+type InventoryHandler struct {
+	finder MachinesFinderInterface
+	token  string
+	host   string
+
+	lock sync.RWMutex
+	body []byte
+	etag string
+	err  error
+}
+
+// Refresh lists the machines again. If listing fails, the inventory is not
+// served until the next successful refresh, since consumers can't tell
+// which machines exist
+func (h *InventoryHandler) Refresh() error {
+	machines, err := h.finder.ListMachines(allMachines)
+	if err != nil {
+		h.lock.Lock()
+		h.err = err
+		h.lock.Unlock()
+
+		return err
+	}
+
+	inventory := Inventory{
+		Host:     h.host,
+		Machines: make([]InventoryMachine, 0, len(machines)),
+	}
+	for _, machine := range machines {
+		inventory.Machines = append(inventory.Machines, NewInventoryMachine(machine))
+	}
+
+	body, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.body = body
+	h.etag = fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	h.err = nil
+
+	return nil
+}
+
+func (h *InventoryHandler) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *InventoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.lock.RLock()
+	body, etag, err := h.body, h.etag, h.err
+	h.lock.RUnlock()
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("couldn't list machines: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	if body == nil {
+		http.Error(w, "inventory is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(body)
+}
+
+// etagMatches checks the If-None-Match header, which may list several
+// ETags or be `*`. ETags are compared weakly, as the header requires
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewInventoryHandler(finder MachinesFinderInterface, token string, host string) *InventoryHandler {
+	return &InventoryHandler{
+		finder: finder,
+		token:  token,
+		host:   host,
+	}
+}
@@ -0,0 +1,141 @@
+package cleaner
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getInventoryHandler(t *testing.T, machines []Machine, err error) *InventoryHandler {
+	finder := &FakeMachinesFinder{t: t}
+	finder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return machines, err
+	}
+
+	return NewInventoryHandler(finder, "secret", "manager-1")
+}
+
+func inventoryRequest(handler http.Handler, token string, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/inventory", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestInventoryHandler(t *testing.T) {
+	createdAt := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	handler := getInventoryHandler(t, []Machine{
+		{Name: "runner-abc123-1", Driver: "digitalocean", InstanceID: "10", CreatedAt: createdAt, State: MachineStateComplete, Directory: "/machines"},
+		{Name: "runner-abc123-2", CreatedAt: createdAt, State: MachineStateCorrupt, Error: errors.New("invalid config.json")},
+	}, nil)
+	require.NoError(t, handler.Refresh())
+
+	resp := inventoryRequest(handler, "secret", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"host": "manager-1", "machines": [
+		{"name": "runner-abc123-1", "driver": "digitalocean", "instance_id": "10", "created_at": "2018-01-01T10:00:00Z", "state": "complete"},
+		{"name": "runner-abc123-2", "created_at": "2018-01-01T10:00:00Z", "state": "corrupt", "error": "invalid config.json"}
+	]}`, resp.Body.String())
+
+	etag := resp.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	resp = inventoryRequest(handler, "secret", etag)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Empty(t, resp.Body.String())
+
+	resp = inventoryRequest(handler, "secret", `"other"`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	for _, ifNoneMatch := range []string{`"other", ` + etag, "W/" + etag, "*"} {
+		resp = inventoryRequest(handler, "secret", ifNoneMatch)
+		assert.Equal(t, http.StatusNotModified, resp.Code, "Should match If-None-Match: %s", ifNoneMatch)
+	}
+}
+
+func TestInventoryHandlerHead(t *testing.T) {
+	handler := getInventoryHandler(t, []Machine{{Name: "runner-abc123-1", State: MachineStateComplete}}, nil)
+	require.NoError(t, handler.Refresh())
+
+	req := httptest.NewRequest(http.MethodHead, "/inventory", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("ETag"))
+	assert.NotEqual(t, "0", resp.Header().Get("Content-Length"))
+	assert.Empty(t, resp.Body.String(), "Should not send the body for HEAD")
+}
+
+func TestInventoryHandlerAuthentication(t *testing.T) {
+	handler := getInventoryHandler(t, nil, nil)
+	require.NoError(t, handler.Refresh())
+
+	assert.Equal(t, http.StatusUnauthorized, inventoryRequest(handler, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, inventoryRequest(handler, "invalid", "").Code)
+
+	handler.token = ""
+	assert.Equal(t, http.StatusUnauthorized, inventoryRequest(handler, "", "").Code, "Should never serve the inventory without a token")
+}
+
+func TestInventoryHandlerListingErrors(t *testing.T) {
+	handler := getInventoryHandler(t, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, inventoryRequest(handler, "secret", "").Code, "Should not serve the inventory before first refresh")
+
+	require.NoError(t, handler.Refresh())
+	assert.Equal(t, http.StatusOK, inventoryRequest(handler, "secret", "").Code)
+
+	handler.finder.(*FakeMachinesFinder).listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return nil, &MachinesDirectoryError{Directory: "/machines", Reason: "not mounted"}
+	}
+	assert.Error(t, handler.Refresh())
+	assert.Equal(t, http.StatusServiceUnavailable, inventoryRequest(handler, "secret", "").Code)
+}
+
+func TestRemoteMachinesFinderUsesETag(t *testing.T) {
+	machines := []Machine{
+		{Name: "runner-abc123-1", Driver: "digitalocean", InstanceID: "10", State: MachineStateComplete},
+	}
+	handler := getInventoryHandler(t, machines, nil)
+	require.NoError(t, handler.Refresh())
+
+	var statuses []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		statuses = append(statuses, recorder.Code)
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	}))
+	defer server.Close()
+
+	finder := NewRemoteMachinesFinder(server.URL, "secret")
+	for i := 0; i < 2; i++ {
+		found, err := finder.ListMachines(regexp.MustCompile("^runner-abc123"))
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		assert.Equal(t, "10", found[0].InstanceID)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusNotModified}, statuses)
+}
@@ -0,0 +1,110 @@
+package cleaner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInventoryServer(t *testing.T, status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestRemoteMachinesFinder(t *testing.T) {
+	server := newInventoryServer(t, http.StatusOK, `{"host": "manager-2", "machines": [
+		{"name": "runner-abc123-1", "driver": "digitalocean", "instance_id": "10", "created_at": "2018-01-01T10:00:00Z", "state": "complete"},
+		{"name": "runner-abc123-2", "created_at": "2018-01-01T10:00:00Z", "state": "corrupt", "error": "invalid config.json"},
+		{"name": "runner-abc123-3", "created_at": "2018-01-01T10:00:00Z", "state": "removing"},
+		{"name": "other-1", "driver": "digitalocean", "instance_id": "11", "created_at": "2018-01-01T10:00:00Z", "state": "complete"}
+	]}`)
+	defer server.Close()
+
+	machines, err := NewRemoteMachinesFinder(server.URL, "secret").ListMachines(regexp.MustCompile("^runner-abc123"))
+	require.NoError(t, err)
+	require.Len(t, machines, 3)
+
+	createdAt := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, Machine{Name: "runner-abc123-1", Driver: "digitalocean", InstanceID: "10", CreatedAt: createdAt, State: MachineStateComplete, Inventory: server.URL}, machines[0])
+
+	assert.Equal(t, MachineStateCorrupt, machines[1].State)
+	assert.EqualError(t, machines[1].Error, "invalid config.json")
+
+	assert.Equal(t, MachineStateCorrupt, machines[2].State, "Should treat unknown state as corrupt")
+}
+
+func TestRemoteMachinesFinderErrors(t *testing.T) {
+	examples := map[string]struct {
+		status int
+		body   string
+		token  string
+	}{
+		"unauthorized":      {status: http.StatusOK, body: `{"machines": []}`, token: "invalid"},
+		"server error":      {status: http.StatusInternalServerError, token: "secret"},
+		"invalid inventory": {status: http.StatusOK, body: `[`, token: "secret"},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			server := newInventoryServer(t, example.status, example.body)
+			defer server.Close()
+
+			_, err := NewRemoteMachinesFinder(server.URL, example.token).ListMachines(regexp.MustCompile("^runner-abc123"))
+			assert.True(t, IsInventoryError(err), "Should return InventoryError, got %v", err)
+		})
+	}
+}
+
+func TestMultiMachinesFinder(t *testing.T) {
+	directory, err := ioutil.TempDir("", "stores")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	firstDirectory := filepath.Join(directory, "first")
+	secondDirectory := filepath.Join(directory, "second")
+	createMachineConfig(t, firstDirectory, "runner-abc123-1", `{"DriverName": "digitalocean", "Driver": {"DropletID": 1}}`)
+	createMachineConfig(t, secondDirectory, "runner-abc123-2", `{"DriverName": "digitalocean", "Driver": {"DropletID": 2}}`)
+
+	server := newInventoryServer(t, http.StatusOK, `{"machines": [
+		{"name": "runner-abc123-3", "driver": "digitalocean", "instance_id": "3", "created_at": "2018-01-01T10:00:00Z", "state": "complete"}
+	]}`)
+	defer server.Close()
+
+	finder := NewMultiMachinesFinder(
+		NewRemoteMachinesFinder(server.URL, "secret"),
+		NewMachinesFinder(firstDirectory),
+		NewMachinesFinder(secondDirectory),
+	)
+	assert.Equal(t, firstDirectory, finder.GetMachinesDirectory())
+
+	machines, err := finder.ListMachines(regexp.MustCompile("^runner-abc123"))
+	require.NoError(t, err)
+	require.Len(t, machines, 3)
+
+	assert.Equal(t, "3", machines[0].InstanceID)
+	assert.Equal(t, server.URL, machines[0].Inventory)
+	assert.Equal(t, "1", machines[1].InstanceID)
+	assert.Equal(t, firstDirectory, machines[1].Directory)
+	assert.Equal(t, "2", machines[2].InstanceID)
+	assert.Equal(t, secondDirectory, machines[2].Directory)
+
+	server.Close()
+	_, err = finder.ListMachines(regexp.MustCompile("^runner-abc123"))
+	assert.Error(t, err, "Should fail if any of the finders fails")
+}
@@ -0,0 +1,114 @@
+package cleaner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Advisory flock(2) locks shared with other tools working on the machines
+// directory; the protocol is described in README
+const (
+	StoreLockFile     = "store.lock"
+	machineLockSuffix = ".lock"
+
+	DefaultStoreLockTimeout = 10 * time.Second
+	storeLockPollInterval   = 100 * time.Millisecond
+)
+
+var errLocked = errors.New("locked by another process")
+
+type fileLock struct {
+	file *os.File
+}
+
+func (l *fileLock) unlock() error {
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// remove removes the lock file and unlocks it
+func (l *fileLock) remove() error {
+	err := os.Remove(l.file.Name())
+	if unlockErr := l.unlock(); err == nil {
+		err = unlockErr
+	}
+
+	return err
+}
+
+// tryLockFile locks the file exclusively without waiting; errLocked is
+// returned if the file is locked by another process
+func tryLockFile(path string) (*fileLock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, errLocked
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		locked, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return &fileLock{file: file}, nil
+		}
+
+		// the file was removed by the previous holder; lock the new one
+		file.Close()
+	}
+}
+
+func lockFile(path string, timeout time.Duration) (*fileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := tryLockFile(path)
+		if err != errLocked || time.Now().After(deadline) {
+			return lock, err
+		}
+
+		time.Sleep(storeLockPollInterval)
+	}
+}
+
+type machineLocker struct {
+	directory        string
+	storeLockTimeout time.Duration
+}
+
+func (l *machineLocker) lockMachine(name string) (*fileLock, error) {
+	err := os.MkdirAll(l.directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return tryLockFile(filepath.Join(l.directory, name+machineLockSuffix))
+}
+
+func (l *machineLocker) lockStore() (*fileLock, error) {
+	err := os.MkdirAll(l.directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockFile(filepath.Join(l.directory, StoreLockFile), l.storeLockTimeout)
+}
@@ -0,0 +1,250 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+// MachineStoreInterface removes machines from Docker Machine's store. The
+// machine is the one found while listing the machines; if it changed since
+// then it's not removed and MachineChangedError is returned
+type MachineStoreInterface interface {
+	RemoveMachine(machine Machine) error
+	ArchiveMachine(machine Machine, archiveDirectory string) error
+}
+
+// MachineChangedError means that the machine's directory was created,
+// recreated or modified after the machines were listed
+type MachineChangedError struct {
+	Name   string
+	Reason string
+}
+
+func (e *MachineChangedError) Error() string {
+	return fmt.Sprintf("machine %s changed since listing: %s", e.Name, e.Reason)
+}
+
+func IsMachineChangedError(err error) bool {
+	_, ok := err.(*MachineChangedError)
+	return ok
+}
+
+// IsMachineLocked returns true if the machine was not removed because
+// another process holds its lock
+func IsMachineLocked(err error) bool {
+	return err == errLocked
+}
+
+// DockerMachineStore removes machines the way `docker-machine rm -f` does:
+// the machine's directory is removed from the store and resources that the
+// driver registered on the provider's side (the SSH key) are released. As
+// with `--force`, a failure of the driver's cleanup is only logged. Machines
+// are removed from the directory they were listed from, or from
+// machinesDirectory if it's not known
+type DockerMachineStore struct {
+	machinesDirectory string
+	lockDirectory     string
+	storeLockTimeout  time.Duration
+	provider          client.CloudProvider
+}
+
+// SetLockDirectory sets where the lock files are created. By default it's
+// the `locks` directory of machine's Docker Machine storage, next to
+// `machines` and `certs`
+func (s *DockerMachineStore) SetLockDirectory(directory string) {
+	s.lockDirectory = directory
+}
+
+func (s *DockerMachineStore) machinesDirectoryOf(machine Machine) string {
+	if machine.Directory != "" {
+		return machine.Directory
+	}
+
+	return s.machinesDirectory
+}
+
+func (s *DockerMachineStore) locker(machinesDirectory string) *machineLocker {
+	directory := s.lockDirectory
+	if directory == "" {
+		directory = filepath.Join(filepath.Dir(filepath.Clean(machinesDirectory)), "locks")
+	}
+
+	return &machineLocker{
+		directory:        directory,
+		storeLockTimeout: s.storeLockTimeout,
+	}
+}
+
+// machinePath returns the machine's directory; in Docker Machine's store
+// layout machine names are always single path elements
+func (s *DockerMachineStore) machinePath(machine Machine) (string, error) {
+	if machine.Inventory != "" {
+		return "", fmt.Errorf("machine %s is listed by the %s inventory and can't be removed locally", machine.Name, machine.Inventory)
+	}
+
+	name := machine.Name
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("invalid machine name %q", name)
+	}
+
+	return filepath.Join(s.machinesDirectoryOf(machine), name), nil
+}
+
+// revalidate reads the machine again and compares it with the listed one,
+// so a machine created or recreated in the meantime is never removed.
+// Machine with empty state wasn't found while listing
+func (s *DockerMachineStore) revalidate(listed Machine, path string) (exists bool, err error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if listed.State == "" {
+		return true, &MachineChangedError{Name: listed.Name, Reason: "the directory was created after listing"}
+	}
+
+	current := readMachine(s.machinesDirectoryOf(listed), listed.Name)
+	if current.State != listed.State {
+		return true, &MachineChangedError{Name: listed.Name, Reason: fmt.Sprintf("state changed from %s to %s", listed.State, current.State)}
+	}
+
+	if current.InstanceID != listed.InstanceID || current.Driver != listed.Driver {
+		return true, &MachineChangedError{Name: listed.Name, Reason: fmt.Sprintf("instance changed from %s to %s", listed.InstanceID, current.InstanceID)}
+	}
+
+	return true, nil
+}
+
+func (s *DockerMachineStore) removeDriverResources(machine Machine) {
+	if machine.State != MachineStateComplete || machine.SSHKeyID == "" {
+		return
+	}
+
+	// the driver removes the key only if it created it; a key given with
+	// `--digitalocean-ssh-key-fingerprint` is used by other machines too
+	if machine.SSHKeyFingerprint != "" {
+		return
+	}
+
+	if machineDriverName(machine) != s.provider.Name() {
+		return
+	}
+
+	remover, ok := s.provider.(client.SSHKeyRemover)
+	if !ok {
+		return
+	}
+
+	logrus.Infof("Removing SSH key %s of machine %s", machine.SSHKeyID, machine.Name)
+	if err := remover.RemoveSSHKey(machine.SSHKeyID); err != nil {
+		logrus.Warnf("Error while removing SSH key %s of machine %s: %v", machine.SSHKeyID, machine.Name, err.Error())
+	}
+}
+
+// withLockedMachine calls fn holding the machine's lock and the store lock,
+// if the machine still is the listed one. The machine's lock file is removed
+// when the machine is gone
+func (s *DockerMachineStore) withLockedMachine(machine Machine, fn func(path string) error) error {
+	path, err := s.machinePath(machine)
+	if err != nil {
+		return err
+	}
+
+	locker := s.locker(s.machinesDirectoryOf(machine))
+	machineLock, err := locker.lockMachine(machine.Name)
+	if err != nil {
+		return err
+	}
+
+	err = s.withLockedStore(locker, machine, path, fn)
+	if err != nil {
+		machineLock.unlock()
+		return err
+	}
+
+	return machineLock.remove()
+}
+
+func (s *DockerMachineStore) withLockedStore(locker *machineLocker, machine Machine, path string, fn func(path string) error) error {
+	storeLock, err := locker.lockStore()
+	if err != nil {
+		return fmt.Errorf("couldn't lock the machines store: %v", err)
+	}
+	defer storeLock.unlock()
+
+	exists, err := s.revalidate(machine, path)
+	if err != nil || !exists {
+		return err
+	}
+
+	err = fn(path)
+	if err != nil {
+		return err
+	}
+
+	// the key is released only when the machine is gone, so the folder
+	// never points to a removed key
+	s.removeDriverResources(machine)
+
+	return nil
+}
+
+func (s *DockerMachineStore) RemoveMachine(machine Machine) error {
+	return s.withLockedMachine(machine, func(path string) error {
+		logrus.Infof("Cleaning up the DockerMachine folder: %s", path)
+
+		return os.RemoveAll(path)
+	})
+}
+
+// ArchiveMachine moves the machine's directory to archiveDirectory instead
+// of deleting it. The provider-side resources are released anyway, as they
+// can't be used without the instance
+func (s *DockerMachineStore) ArchiveMachine(machine Machine, archiveDirectory string) error {
+	return s.withLockedMachine(machine, func(path string) error {
+		err := os.MkdirAll(archiveDirectory, 0700)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(archiveDirectory, archivedMachineName(machine.Name, time.Now()))
+		logrus.Infof("Archiving the DockerMachine folder: %s -> %s", path, target)
+
+		return os.Rename(path, target)
+	})
+}
+
+// archivedMachineName prefixes the name with the archive time; the folder
+// keeps its modification time when it's moved, so it can't tell how long
+// the folder is archived
+func archivedMachineName(name string, archivedAt time.Time) string {
+	return fmt.Sprintf("%d-%s", archivedAt.Unix(), name)
+}
+
+// archivedMachineTime reads the archive time from the name created by
+// archivedMachineName
+func archivedMachineTime(name string) (time.Time, bool) {
+	prefix := strings.SplitN(name, "-", 2)[0]
+
+	timestamp, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(timestamp, 0), true
+}
+
+func NewDockerMachineStore(machinesDirectory string, provider client.CloudProvider) *DockerMachineStore {
+	return &DockerMachineStore{
+		machinesDirectory: machinesDirectory,
+		storeLockTimeout:  DefaultStoreLockTimeout,
+		provider:          provider,
+	}
+}
@@ -0,0 +1,261 @@
+package cleaner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+func newTestMachineStore(t *testing.T) (store *DockerMachineStore, provider *FakeDOClient, machinesDirectory string, cleanup func()) {
+	directory, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+
+	machinesDirectory = filepath.Join(directory, "machines")
+	require.NoError(t, os.Mkdir(machinesDirectory, 0700))
+
+	provider = &FakeDOClient{t: t}
+	store = NewDockerMachineStore(machinesDirectory, provider)
+
+	cleanup = func() {
+		os.RemoveAll(directory)
+	}
+
+	return
+}
+
+func TestDockerMachineStoreRemoveMachine(t *testing.T) {
+	defer registerFakeDriver()()
+
+	examples := map[string]struct {
+		config          string
+		removeSSHKeyErr error
+		expectedKeys    []string
+	}{
+		"machine with SSH key": {
+			config:       `{"DriverName": "fake", "Driver": {"DropletID": 10, "SSHKeyID": 20}}`,
+			expectedKeys: []string{"20"},
+		},
+		"SSH key removal failure": {
+			config:          `{"DriverName": "fake", "Driver": {"DropletID": 10, "SSHKeyID": 20}}`,
+			removeSSHKeyErr: errors.New("api error"),
+			expectedKeys:    []string{"20"},
+		},
+		"machine with SSH key given by fingerprint": {
+			config: `{"DriverName": "fake", "Driver": {"DropletID": 10, "SSHKeyID": 20, "SSHKeyFingerprint": "aa:bb:cc"}}`,
+		},
+		"machine of other driver": {
+			config: `{"DriverName": "digitalocean", "Driver": {"DropletID": 10, "SSHKeyID": 20}}`,
+		},
+		"corrupted machine": {
+			config: `{"DriverName": "fake"`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			store, provider, machinesDirectory, cleanup := newTestMachineStore(t)
+			defer cleanup()
+
+			provider.removeSSHKeyError = example.removeSSHKeyErr
+			createMachineConfig(t, machinesDirectory, "runner-abc123-1", example.config)
+			machine := readMachine(machinesDirectory, "runner-abc123-1")
+
+			assert.NoError(t, store.RemoveMachine(machine))
+			assert.Equal(t, example.expectedKeys, provider.removedSSHKeys)
+
+			_, err := os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+			assert.True(t, os.IsNotExist(err), "Should remove the machine directory")
+
+			_, err = os.Stat(filepath.Join(store.locker(machinesDirectory).directory, "runner-abc123-1.lock"))
+			assert.True(t, os.IsNotExist(err), "Should remove the machine lock file")
+		})
+	}
+}
+
+func TestDockerMachineStoreRemoveMissingMachine(t *testing.T) {
+	store, _, _, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	assert.NoError(t, store.RemoveMachine(Machine{Name: "runner-abc123-1"}))
+}
+
+func TestDockerMachineStoreRejectsInvalidNames(t *testing.T) {
+	store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	for _, name := range []string{"", ".", "..", "../certs"} {
+		assert.Error(t, store.RemoveMachine(Machine{Name: name, State: MachineStateComplete}), "Should reject %q", name)
+	}
+
+	_, err := os.Stat(machinesDirectory)
+	assert.NoError(t, err)
+}
+
+func TestDockerMachineStoreArchiveMachine(t *testing.T) {
+	store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	archiveDirectory := filepath.Join(filepath.Dir(machinesDirectory), "archive")
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{}`)
+	machine := readMachine(machinesDirectory, "runner-abc123-1")
+
+	require.NoError(t, store.ArchiveMachine(machine, archiveDirectory))
+
+	_, err := os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.True(t, os.IsNotExist(err), "Should move the machine directory")
+
+	archived, err := filepath.Glob(filepath.Join(archiveDirectory, "*-runner-abc123-1", "config.json"))
+	require.NoError(t, err)
+	assert.Len(t, archived, 1)
+}
+
+func TestDockerMachineStoreKeepsChangedMachines(t *testing.T) {
+	defer registerFakeDriver()()
+
+	examples := map[string]struct {
+		listed Machine
+	}{
+		"machine created after listing": {
+			listed: Machine{Name: "runner-abc123-1"},
+		},
+		"machine recreated after listing": {
+			listed: Machine{Name: "runner-abc123-1", Driver: "fake", InstanceID: "9", State: MachineStateComplete},
+		},
+		"machine provisioned after listing": {
+			listed: Machine{Name: "runner-abc123-1", State: MachineStateCreating},
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+			defer cleanup()
+
+			createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{"DriverName": "fake", "Driver": {"DropletID": 10}}`)
+
+			err := store.RemoveMachine(example.listed)
+			assert.True(t, IsMachineChangedError(err), "Should return MachineChangedError, got %v", err)
+
+			_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+			assert.NoError(t, err, "Should keep the machine directory")
+		})
+	}
+}
+
+func TestDockerMachineStoreSkipsLockedMachines(t *testing.T) {
+	store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{}`)
+	machine := readMachine(machinesDirectory, "runner-abc123-1")
+
+	lock, err := store.locker(machinesDirectory).lockMachine("runner-abc123-1")
+	require.NoError(t, err)
+
+	err = store.RemoveMachine(machine)
+	assert.True(t, IsMachineLocked(err), "Should skip locked machine, got %v", err)
+
+	_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.NoError(t, err, "Should keep the machine directory")
+
+	require.NoError(t, lock.unlock())
+	assert.NoError(t, store.RemoveMachine(machine))
+}
+
+func TestDockerMachineStoreWaitsForStoreLock(t *testing.T) {
+	store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	store.storeLockTimeout = 0
+
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{}`)
+	machine := readMachine(machinesDirectory, "runner-abc123-1")
+
+	lock, err := store.locker(machinesDirectory).lockStore()
+	require.NoError(t, err)
+	defer lock.unlock()
+
+	assert.Error(t, store.RemoveMachine(machine))
+
+	_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.NoError(t, err, "Should keep the machine directory")
+}
+
+func TestDockerMachineStoreRemovesMachineFromItsDirectory(t *testing.T) {
+	store, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	otherDirectory := filepath.Join(filepath.Dir(machinesDirectory), "other", "machines")
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{}`)
+	createMachineConfig(t, otherDirectory, "runner-abc123-1", `{}`)
+
+	require.NoError(t, store.RemoveMachine(readMachine(otherDirectory, "runner-abc123-1")))
+
+	_, err := os.Stat(filepath.Join(otherDirectory, "runner-abc123-1"))
+	assert.True(t, os.IsNotExist(err), "Should remove the machine from its directory")
+	_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.NoError(t, err, "Should keep the machine in other directory")
+
+	remote := Machine{Name: "runner-abc123-1", State: MachineStateComplete, Inventory: "https://manager-2/inventory"}
+	assert.Error(t, store.RemoveMachine(remote), "Should not remove machines of remote inventories")
+}
+
+func TestHangingDropletFolderIsKeptInDryRun(t *testing.T) {
+	_, _, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	// machine of a driver unknown to the cleaner doesn't own the droplet
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{"DriverName": "unknown", "Driver": {}}`)
+
+	cleaner, provider, machinesFinder := getCleaner(t)
+	store := NewDockerMachineStore(machinesDirectory, provider)
+	cleaner.SetMachineStore(store)
+
+	provider.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+			{ID: "2", Name: "runner-abc123-2", CreatedAt: time.Now().Add(-1 * time.Hour)},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{readMachine(machinesDirectory, "runner-abc123-1")}, nil
+	}
+
+	require.NoError(t, cleaner.Clean())
+
+	assert.Empty(t, provider.removedSSHKeys)
+
+	_, err := os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.NoError(t, err, "Should keep the machine directory")
+	_, err = os.Stat(store.locker(machinesDirectory).directory)
+	assert.True(t, os.IsNotExist(err), "Should not create lock files")
+}
+
+func TestDockerMachineStoreKeepsSSHKeyWhenFolderRemovalFails(t *testing.T) {
+	defer registerFakeDriver()()
+
+	store, provider, machinesDirectory, cleanup := newTestMachineStore(t)
+	defer cleanup()
+
+	createMachineConfig(t, machinesDirectory, "runner-abc123-1", `{"DriverName": "fake", "Driver": {"DropletID": 10, "SSHKeyID": 20}}`)
+	machine := readMachine(machinesDirectory, "runner-abc123-1")
+
+	// archive "directory" that is a file can't be used
+	archiveDirectory := filepath.Join(filepath.Dir(machinesDirectory), "archive")
+	require.NoError(t, ioutil.WriteFile(archiveDirectory, []byte{}, 0600))
+
+	assert.Error(t, store.ArchiveMachine(machine, archiveDirectory))
+	assert.Empty(t, provider.removedSSHKeys, "Should not remove SSH key of the machine that still exists")
+
+	_, err := os.Stat(filepath.Join(machinesDirectory, "runner-abc123-1"))
+	assert.NoError(t, err)
+}
@@ -1,11 +1,14 @@
 package cleaner
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"time"
+
+	"github.com/Sirupsen/logrus"
 )
 
 type MachinesFinderInterface interface {
@@ -15,15 +18,117 @@ type MachinesFinderInterface interface {
 
 type MachinesFinder struct {
 	machinesDirectory string
+	markerFile        string
+}
+
+// MachinesDirectoryError means that the machines directory doesn't look like
+// a real Docker Machine storage (e.g. the volume was not mounted). In such
+// case every droplet would be considered as hanging, so the cleanup must be
+// aborted
+type MachinesDirectoryError struct {
+	Directory string
+	Reason    string
+}
+
+func (e *MachinesDirectoryError) Error() string {
+	return fmt.Sprintf("invalid machines directory %s: %s", e.Directory, e.Reason)
+}
+
+func IsMachinesDirectoryError(err error) bool {
+	_, ok := err.(*MachinesDirectoryError)
+	return ok
+}
+
+type MachineState string
+
+const (
+	// MachineStateCreating is a machine without config.json or without
+	// the instance ID yet, which is normal while Docker Machine provisions it
+	MachineStateCreating MachineState = "creating"
+	// MachineStateComplete is a machine with readable configuration
+	MachineStateComplete MachineState = "complete"
+	// MachineStateCorrupt is a machine whose configuration can't be read
+	MachineStateCorrupt MachineState = "corrupt"
+)
+
+var machineStates = []MachineState{
+	MachineStateCreating,
+	MachineStateComplete,
+	MachineStateCorrupt,
 }
 
 type Machine struct {
-	Name      string
-	DropletId float64
+	Name       string
+	Driver     string
+	InstanceID string
+	IPAddress  string
+	Region     string
+	Size       string
+	Zone       string
+	Project    string
+	// SSHKeyID is the provider-side SSH key registered by the driver;
+	// SSHKeyFingerprint is set when the machine uses a key supplied by the
+	// user, which is shared with other machines and must not be removed
+	SSHKeyID          string
+	SSHKeyFingerprint string
+	// CreatedAt is the modification time of machine's directory or
+	// config.json; Docker Machine doesn't store the creation time
+	CreatedAt time.Time
+	State     MachineState
+	Error     error
+	// Directory is the machines directory where the machine was found;
+	// Inventory is the URL of the remote inventory that listed it
+	Directory string
+	Inventory string
+}
+
+func (m *MachinesFinder) SetMarkerFile(name string) {
+	m.markerFile = name
+}
+
+func (m *MachinesFinder) checkMachinesDirectory(entries []os.FileInfo) error {
+	if m.markerFile != "" {
+		if _, err := os.Stat(filepath.Join(m.machinesDirectory, m.markerFile)); err != nil {
+			return &MachinesDirectoryError{
+				Directory: m.machinesDirectory,
+				Reason:    fmt.Sprintf("marker file %s not found (%v); is the directory mounted?", m.markerFile, err),
+			}
+		}
+
+		return nil
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+
+	// Docker Machine stores its certificates next to the machines
+	// directory, so an empty directory is fine only if it's a part of
+	// a real Docker Machine storage
+	certs, err := os.Stat(filepath.Join(filepath.Dir(filepath.Clean(m.machinesDirectory)), "certs"))
+	if err == nil && certs.IsDir() {
+		return nil
+	}
+
+	return &MachinesDirectoryError{
+		Directory: m.machinesDirectory,
+		Reason:    "the directory is empty and there is no Docker Machine certs directory next to it; is the directory mounted? Use a marker file if it's expected to be empty",
+	}
 }
 
 func (m *MachinesFinder) ListMachines(runnerPrefixRegexp *regexp.Regexp) ([]Machine, error) {
 	entries, err := ioutil.ReadDir(m.machinesDirectory)
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return nil, &MachinesDirectoryError{
+			Directory: m.machinesDirectory,
+			Reason:    fmt.Sprintf("%v; is the directory mounted?", err),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.checkMachinesDirectory(entries)
 	if err != nil {
 		return nil, err
 	}
@@ -36,45 +141,70 @@ func (m *MachinesFinder) ListMachines(runnerPrefixRegexp *regexp.Regexp) ([]Mach
 			continue
 		}
 
-		configFile := fmt.Sprintf("%s/%s/config.json", m.machinesDirectory, name)
-		dropletId := float64(0)
+		machine := readMachine(m.machinesDirectory, name)
+		if machine.State == MachineStateCorrupt {
+			logrus.Warnf("Machine '%s' has corrupted configuration: %v", name, machine.Error)
+		}
 
-		if _, err := os.Stat(configFile); os.IsNotExist(err) {
-			return nil, err
-		} else {
-			dockerMachineConfigFile, err := os.Open(configFile)
-			if err != nil {
-				return nil, err
-			}
+		machines = append(machines, machine)
+	}
 
-			jsonByteValue, _ := ioutil.ReadAll(dockerMachineConfigFile)
-			dockerMachineConfigFile.Close()
+	return machines, nil
+}
 
-			var dockerMachineConfigParsed map[string]interface{}
-			err = json.Unmarshal([]byte(jsonByteValue), &dockerMachineConfigParsed)
+// readMachine never fails: problems with the machine's configuration are
+// reported with the machine's state, so one bad entry doesn't abort the
+// whole cleanup
+func readMachine(machinesDirectory, name string) Machine {
+	machine := Machine{
+		Name:      name,
+		State:     MachineStateCreating,
+		Directory: machinesDirectory,
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	machineDirectory := filepath.Join(machinesDirectory, name)
+	if info, err := os.Stat(machineDirectory); err == nil {
+		machine.CreatedAt = info.ModTime()
+	}
 
-			if driverConfig, ok := dockerMachineConfigParsed["Driver"].(map[string]interface{}); ok {
+	configFile := filepath.Join(machineDirectory, "config.json")
+	info, err := os.Stat(configFile)
+	if os.IsNotExist(err) {
+		// Docker Machine writes config.json after the instance is created
+		return machine
+	}
+	if err != nil {
+		return corruptMachine(machine, err)
+	}
+	machine.CreatedAt = info.ModTime()
 
-				dropletIdString := driverConfig["DropletID"].(float64)
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return corruptMachine(machine, err)
+	}
 
-				if dropletIdString != 0 {
-					dropletId = dropletIdString
-				}
-			}
+	host, err := parseDockerMachineHost(data)
+	if err != nil {
+		return corruptMachine(machine, err)
+	}
 
-		}
+	known, err := host.updateMachine(&machine)
+	if err != nil {
+		return corruptMachine(machine, err)
+	}
 
-		machines = append(machines, Machine{
-			Name:      name,
-			DropletId: dropletId,
-		})
+	if machine.InstanceID != "" || !known {
+		machine.State = MachineStateComplete
 	}
 
-	return machines, nil
+	return machine
+}
+
+func corruptMachine(machine Machine, err error) Machine {
+	machine.State = MachineStateCorrupt
+	machine.Error = err
+
+	return machine
 }
 
 func (m *MachinesFinder) GetMachinesDirectory() string {
@@ -86,3 +216,43 @@ func NewMachinesFinder(machinesDirectory string) *MachinesFinder {
 		machinesDirectory: machinesDirectory,
 	}
 }
+
+// MultiMachinesFinder merges machines listed by several finders (e.g. local
+// machines directories and remote inventories of other hosts using the same
+// cloud account). If any of them fails, the cleanup must be aborted, since
+// machines it would list could be taken as hanging
+type MultiMachinesFinder struct {
+	finders []MachinesFinderInterface
+}
+
+func (m *MultiMachinesFinder) ListMachines(runnerPrefixRegexp *regexp.Regexp) ([]Machine, error) {
+	var machines []Machine
+
+	for _, finder := range m.finders {
+		found, err := finder.ListMachines(runnerPrefixRegexp)
+		if err != nil {
+			return nil, err
+		}
+
+		machines = append(machines, found...)
+	}
+
+	return machines, nil
+}
+
+// GetMachinesDirectory returns the first local machines directory
+func (m *MultiMachinesFinder) GetMachinesDirectory() string {
+	for _, finder := range m.finders {
+		if directory := finder.GetMachinesDirectory(); directory != "" {
+			return directory
+		}
+	}
+
+	return ""
+}
+
+func NewMultiMachinesFinder(finders ...MachinesFinderInterface) *MultiMachinesFinder {
+	return &MultiMachinesFinder{
+		finders: finders,
+	}
+}
@@ -0,0 +1,198 @@
+package cleaner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createMachineConfig(t *testing.T, machinesDirectory, name, config string) {
+	machineDirectory := filepath.Join(machinesDirectory, name)
+	require.NoError(t, os.MkdirAll(machineDirectory, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(machineDirectory, "config.json"), []byte(config), 0600))
+}
+
+func TestMachinesFinderDriverInstanceIDs(t *testing.T) {
+	machinesDirectory, err := ioutil.TempDir("", "machines")
+	require.NoError(t, err)
+	defer os.RemoveAll(machinesDirectory)
+
+	createMachineConfig(t, machinesDirectory, "runner-abc123-do", `{"DriverName": "digitalocean", "Driver": {"DropletID": 1234}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-legacy", `{"Driver": {"DropletID": 5678}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-ec2", `{"DriverName": "amazonec2", "Driver": {"InstanceId": "i-0abc"}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-gce", `{"DriverName": "google", "Driver": {"MachineName": "runner-abc123-gce", "Zone": "us-east1-b", "Project": "runners"}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-hetzner", `{"DriverName": "hetzner", "Driver": {"ServerID": 42}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-linode", `{"DriverName": "linode", "Driver": {"InstanceID": 43}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-vultr", `{"DriverName": "vultr", "Driver": {"MachineID": "cb676a46"}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-openstack", `{"DriverName": "openstack", "Driver": {"MachineId": "0f5b6d1e-uuid"}}`)
+	createMachineConfig(t, machinesDirectory, "other-machine", `{"DriverName": "amazonec2", "Driver": {"InstanceId": "i-0def"}}`)
+
+	machines, err := NewMachinesFinder(machinesDirectory).ListMachines(regexp.MustCompile("^runner-abc123"))
+	require.NoError(t, err)
+
+	for i := range machines {
+		assert.False(t, machines[i].CreatedAt.IsZero(), "Should set creation time of %s", machines[i].Name)
+		assert.Equal(t, machinesDirectory, machines[i].Directory)
+		machines[i].CreatedAt = time.Time{}
+		machines[i].Directory = ""
+	}
+
+	assert.Equal(t, []Machine{
+		{Name: "runner-abc123-do", Driver: "digitalocean", InstanceID: "1234", State: MachineStateComplete},
+		{Name: "runner-abc123-ec2", Driver: "amazonec2", InstanceID: "i-0abc", State: MachineStateComplete},
+		{Name: "runner-abc123-gce", Driver: "google", InstanceID: "us-east1-b/runner-abc123-gce", Zone: "us-east1-b", Project: "runners", State: MachineStateComplete},
+		{Name: "runner-abc123-hetzner", Driver: "hetzner", InstanceID: "42", State: MachineStateComplete},
+		{Name: "runner-abc123-legacy", Driver: "", InstanceID: "5678", State: MachineStateComplete},
+		{Name: "runner-abc123-linode", Driver: "linode", InstanceID: "43", State: MachineStateComplete},
+		{Name: "runner-abc123-openstack", Driver: "openstack", InstanceID: "0f5b6d1e-uuid", State: MachineStateComplete},
+		{Name: "runner-abc123-vultr", Driver: "vultr", InstanceID: "cb676a46", State: MachineStateComplete},
+	}, machines)
+}
+
+func TestMachinesFinderDirectoryChecks(t *testing.T) {
+	examples := map[string]struct {
+		createMachine bool
+		createCerts   bool
+		markerFile    string
+		createMarker  bool
+		expectedError bool
+	}{
+		"directory with machines":              {createMachine: true},
+		"empty directory":                      {expectedError: true},
+		"empty directory next to certs":        {createCerts: true},
+		"missing marker":                       {createMachine: true, markerFile: ".hdc", expectedError: true},
+		"empty directory with existing marker": {markerFile: ".hdc", createMarker: true},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			storageDirectory, err := ioutil.TempDir("", "machine")
+			require.NoError(t, err)
+			defer os.RemoveAll(storageDirectory)
+
+			machinesDirectory := filepath.Join(storageDirectory, "machines")
+			require.NoError(t, os.Mkdir(machinesDirectory, 0700))
+
+			if example.createMachine {
+				createMachineConfig(t, machinesDirectory, "runner-abc123-do", `{"DriverName": "digitalocean", "Driver": {"DropletID": 1234}}`)
+			}
+			if example.createCerts {
+				require.NoError(t, os.Mkdir(filepath.Join(storageDirectory, "certs"), 0700))
+			}
+			if example.createMarker {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(machinesDirectory, example.markerFile), []byte{}, 0600))
+			}
+
+			finder := NewMachinesFinder(machinesDirectory)
+			finder.SetMarkerFile(example.markerFile)
+
+			_, err = finder.ListMachines(regexp.MustCompile("^runner-abc123"))
+			if example.expectedError {
+				assert.True(t, IsMachinesDirectoryError(err), "Should return machines directory error, got: %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMachinesFinderMissingDirectory(t *testing.T) {
+	storageDirectory, err := ioutil.TempDir("", "machine")
+	require.NoError(t, err)
+	defer os.RemoveAll(storageDirectory)
+
+	finder := NewMachinesFinder(filepath.Join(storageDirectory, "machines"))
+
+	_, err = finder.ListMachines(regexp.MustCompile("^runner-abc123"))
+	assert.True(t, IsMachinesDirectoryError(err), "Should return machines directory error, got: %v", err)
+}
+
+func TestMachinesFinderMachineStates(t *testing.T) {
+	machinesDirectory, err := ioutil.TempDir("", "machines")
+	require.NoError(t, err)
+	defer os.RemoveAll(machinesDirectory)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(machinesDirectory, "runner-abc123-no-config"), 0700))
+	createMachineConfig(t, machinesDirectory, "runner-abc123-no-id", `{"DriverName": "digitalocean", "Driver": {"DropletID": 0}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-invalid-id", `{"DriverName": "digitalocean", "Driver": {"DropletID": true}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-broken-json", `{"DriverName": "digitalocean", "Dri`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-no-driver", `{"DriverName": "digitalocean"}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-complete", `{"DriverName": "digitalocean", "Driver": {"DropletID": 1234}}`)
+
+	machines, err := NewMachinesFinder(machinesDirectory).ListMachines(regexp.MustCompile("^runner-abc123"))
+	require.NoError(t, err, "Single broken machine should not fail the listing")
+
+	states := make(map[string]MachineState)
+	for _, machine := range machines {
+		states[machine.Name] = machine.State
+		if machine.State == MachineStateCorrupt {
+			assert.Error(t, machine.Error, "Corrupted machine %s should have the error", machine.Name)
+		}
+	}
+
+	assert.Equal(t, map[string]MachineState{
+		"runner-abc123-no-config":   MachineStateCreating,
+		"runner-abc123-no-id":       MachineStateCreating,
+		"runner-abc123-invalid-id":  MachineStateCorrupt,
+		"runner-abc123-broken-json": MachineStateCorrupt,
+		"runner-abc123-no-driver":   MachineStateCorrupt,
+		"runner-abc123-complete":    MachineStateComplete,
+	}, states)
+}
+
+func TestMachinesFinderReadsMachineDetails(t *testing.T) {
+	machinesDirectory, err := ioutil.TempDir("", "machines")
+	require.NoError(t, err)
+	defer os.RemoveAll(machinesDirectory)
+
+	createMachineConfig(t, machinesDirectory, "runner-abc123-do", `{
+		"ConfigVersion": 3,
+		"Driver": {
+			"IPAddress": "203.0.113.10",
+			"MachineName": "runner-abc123-do",
+			"SSHUser": "root",
+			"SSHPort": 22,
+			"StorePath": "/root/.docker/machine",
+			"DropletID": 1234,
+			"DropletName": "",
+			"Image": "ubuntu-16-04-x64",
+			"Region": "nyc1",
+			"Size": "s-2vcpu-2gb"
+		},
+		"DriverName": "digitalocean",
+		"HostOptions": {
+			"Driver": "",
+			"Memory": 0,
+			"Disk": 0,
+			"AuthOptions": {"StorePath": "/root/.docker/machine/machines/runner-abc123-do"}
+		},
+		"Name": "runner-abc123-do"
+	}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-ec2", `{"DriverName": "amazonec2", "Driver": {"IPAddress": "203.0.113.11", "InstanceId": "i-0abc", "Region": "us-east-1", "Zone": "a", "InstanceType": "m4.large"}}`)
+	createMachineConfig(t, machinesDirectory, "runner-abc123-unknown", `{"DriverName": "virtualbox", "Driver": {"IPAddress": "192.168.99.100"}}`)
+
+	machines, err := NewMachinesFinder(machinesDirectory).ListMachines(regexp.MustCompile("^runner-abc123"))
+	require.NoError(t, err)
+	require.Len(t, machines, 3)
+
+	assert.Equal(t, "1234", machines[0].InstanceID)
+	assert.Equal(t, "203.0.113.10", machines[0].IPAddress)
+	assert.Equal(t, "nyc1", machines[0].Region)
+	assert.Equal(t, "s-2vcpu-2gb", machines[0].Size)
+
+	assert.Equal(t, "i-0abc", machines[1].InstanceID)
+	assert.Equal(t, "203.0.113.11", machines[1].IPAddress)
+	assert.Equal(t, "us-east-1", machines[1].Region)
+	assert.Equal(t, "a", machines[1].Zone)
+	assert.Equal(t, "m4.large", machines[1].Size)
+
+	assert.Equal(t, "", machines[2].InstanceID)
+	assert.Equal(t, "192.168.99.100", machines[2].IPAddress)
+	assert.Equal(t, MachineStateComplete, machines[2].State, "Machine of unknown driver should be complete")
+}
@@ -0,0 +1,62 @@
+package cleaner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+const (
+	EventUndeletableDroplet    = "undeletable_droplet"
+	EventDeletionLimitExceeded = "deletion_limit_exceeded"
+)
+
+type Notification struct {
+	Event   string           `json:"event"`
+	Message string           `json:"message"`
+	Droplet *client.Instance `json:"droplet,omitempty"`
+	Time    time.Time        `json:"time"`
+}
+
+type NotifierInterface interface {
+	Notify(Notification) error
+}
+
+// WebhookNotifier sends notifications as JSON documents POSTed to the
+// configured URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url: url,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
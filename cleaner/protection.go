@@ -0,0 +1,53 @@
+package cleaner
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+// SetProtection configures the denylist: droplets having any of the tags, or
+// whose name or ID is on the list, are never stopped nor deleted
+func (c *HangingDropletsCleaner) SetProtection(tags []string, droplets []string) {
+	c.protectedTags = make(map[string]bool)
+	for _, tag := range tags {
+		c.protectedTags[tag] = true
+	}
+
+	c.protectedDroplets = make(map[string]bool)
+	for _, droplet := range droplets {
+		c.protectedDroplets[droplet] = true
+	}
+}
+
+func (c *HangingDropletsCleaner) isProtected(droplet client.Instance) bool {
+	if c.protectedDroplets[droplet.Name] || c.protectedDroplets[droplet.ID] {
+		return true
+	}
+
+	for _, tag := range droplet.Tags {
+		if c.protectedTags[tag] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *HangingDropletsCleaner) reportProtectedDroplets(droplets []client.Instance) {
+	var protected []string
+	for _, droplet := range droplets {
+		if c.isProtected(droplet) {
+			protected = append(protected, droplet.Name)
+		}
+	}
+
+	atomic.StoreInt64(&c.numberOfProtectedDroplets, int64(len(protected)))
+
+	if len(protected) > 0 {
+		logrus.Infof("Found %d protected droplets that won't be stopped nor deleted: %s", len(protected), strings.Join(protected, ", "))
+	}
+}
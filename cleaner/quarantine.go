@@ -0,0 +1,141 @@
+package cleaner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+type Policy string
+
+const (
+	// PolicyDelete stops and deletes hanging droplets in one pass
+	PolicyDelete Policy = "delete"
+	// PolicyQuarantine stops and tags hanging droplets, and deletes them
+	// only after the quarantine hold
+	PolicyQuarantine Policy = "quarantine"
+
+	QuarantineTagPrefix   = "hdc-quarantined:"
+	DefaultQuarantineHold = 24 * time.Hour
+)
+
+// SetPolicy configures what happens with hanging droplets. The quarantine
+// policy requires a provider that can tag instances
+func (c *HangingDropletsCleaner) SetPolicy(policy string, quarantineHold time.Duration) error {
+	switch Policy(policy) {
+	case PolicyDelete:
+	case PolicyQuarantine:
+		if _, ok := c.client.(client.InstanceTagger); !ok {
+			return fmt.Errorf("Policy '%s' is not supported by the '%s' provider", policy, c.client.Name())
+		}
+	default:
+		return fmt.Errorf("Unknown policy '%s'", policy)
+	}
+
+	c.policy = Policy(policy)
+	c.quarantineHold = quarantineHold
+
+	return nil
+}
+
+func quarantineTag(now time.Time) string {
+	return fmt.Sprintf("%s%d", QuarantineTagPrefix, now.Unix())
+}
+
+func quarantineTags(droplet client.Instance) (tags []string) {
+	for _, tag := range droplet.Tags {
+		if strings.HasPrefix(tag, QuarantineTagPrefix) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return
+}
+
+// quarantinedAt returns the time from the quarantine tag of the droplet, or
+// zero time if the droplet is not quarantined
+func quarantinedAt(droplet client.Instance) time.Time {
+	for _, tag := range quarantineTags(droplet) {
+
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(tag, QuarantineTagPrefix), 10, 64)
+		if err != nil {
+			logrus.Warnf("Droplet '%s' has invalid quarantine tag '%s'", droplet.Name, tag)
+			continue
+		}
+
+		return time.Unix(timestamp, 0)
+	}
+
+	return time.Time{}
+}
+
+func (c *HangingDropletsCleaner) quarantineDroplet(droplet client.Instance) dropletResult {
+	result := dropletResult{droplet: droplet}
+
+	since := quarantinedAt(droplet)
+	if since.IsZero() {
+		logrus.Infof("Will stop and quarantine: %s (created_at: %s)", droplet.Name, droplet.CreatedAt.Format(time.RFC3339))
+		if !c.delete {
+			return result
+		}
+
+		// droplet that wasn't stopped is not quarantined, so the hold
+		// starts only once it's really stopped
+		result.stopErr = c.stopDroplet(droplet)
+		if result.stopErr != nil {
+			return result
+		}
+
+		tag := quarantineTag(time.Now())
+		if err := c.client.(client.InstanceTagger).TagInstance(droplet, tag); err != nil {
+			logrus.Errorf("Error while tagging droplet '%s' with '%s': %v", droplet.Name, tag, err.Error())
+			return result
+		}
+
+		atomic.AddInt64(&c.totalNumberOfQuarantinedDroplets, 1)
+
+		return result
+	}
+
+	if time.Since(since) < c.quarantineHold {
+		logrus.Infof("Droplet '%s' is in quarantine since %s; will be deleted after %s", droplet.Name, since.Format(time.RFC3339), since.Add(c.quarantineHold).Format(time.RFC3339))
+		return result
+	}
+
+	logrus.Infof("Will delete quarantined: %s (quarantined_at: %s)", droplet.Name, since.Format(time.RFC3339))
+	if !c.delete {
+		return result
+	}
+
+	result.deleteErr = c.snapshotAndDeleteDroplet(droplet)
+	result.deleted = result.deleteErr == nil
+
+	return result
+}
+
+// releaseQuarantine removes the quarantine tags from a droplet that has a
+// machine again, e.g. when the machine's folder was missing only for a while,
+// so it's not deleted when the hold expires
+func (c *HangingDropletsCleaner) releaseQuarantine(droplet client.Instance) {
+	tagger, ok := c.client.(client.InstanceTagger)
+	if !ok {
+		return
+	}
+
+	for _, tag := range quarantineTags(droplet) {
+		logrus.Infof("Will release from quarantine: %s (tag: %s)", droplet.Name, tag)
+		if !c.delete {
+			continue
+		}
+
+		if err := tagger.UntagInstance(droplet, tag); err != nil {
+			logrus.Errorf("Error while removing tag '%s' from droplet '%s': %v", tag, droplet.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+const (
+	// SnapshotNamePrefix marks snapshots created by the cleaner; only such
+	// snapshots are removed by the expiry sweeper
+	SnapshotNamePrefix       = "hdc-snapshot-"
+	DefaultSnapshotRetention = 7 * 24 * time.Hour
+)
+
+// SetSnapshotPolicy makes the cleaner snapshot droplets whose names start
+// with one of the prefixes before deleting them, and remove the snapshots
+// after the retention. Snapshotting requires a provider that can snapshot
+// instances. The retention applies to all snapshots taken by the cleaner,
+// also when no prefix is configured anymore
+func (c *HangingDropletsCleaner) SetSnapshotPolicy(prefixes []string, retention time.Duration) error {
+	c.snapshotRetention = retention
+
+	if len(prefixes) < 1 {
+		return nil
+	}
+
+	if _, ok := c.client.(client.InstanceSnapshotter); !ok {
+		return fmt.Errorf("Snapshots are not supported by the '%s' provider", c.client.Name())
+	}
+
+	c.snapshotPrefixes = prefixes
+
+	return nil
+}
+
+func (c *HangingDropletsCleaner) needsSnapshot(droplet client.Instance) bool {
+	for _, prefix := range c.snapshotPrefixes {
+		if strings.HasPrefix(droplet.Name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *HangingDropletsCleaner) snapshotDroplet(droplet client.Instance) error {
+	name := fmt.Sprintf("%s%s-%d", SnapshotNamePrefix, droplet.Name, time.Now().Unix())
+	logrus.Infof("Taking snapshot '%s' of droplet '%s'", name, droplet.Name)
+
+	id, err := c.client.(client.InstanceSnapshotter).SnapshotInstance(droplet, name)
+	if err != nil {
+		atomic.AddInt64(&c.totalNumberOfSnapshotErrors, 1)
+		logrus.Errorf("Error while taking snapshot of droplet '%s': %v", droplet.Name, err.Error())
+		return err
+	}
+
+	atomic.AddInt64(&c.totalNumberOfSnapshots, 1)
+	c.audit(AuditEventDropletSnapshotted, &droplet, &client.Snapshot{ID: id, Name: name, CreatedAt: time.Now()})
+
+	return nil
+}
+
+// snapshotAndDeleteDroplet deletes the droplet, taking its snapshot first if
+// it's configured for the droplet. If the snapshot fails, the droplet is kept
+func (c *HangingDropletsCleaner) snapshotAndDeleteDroplet(droplet client.Instance) error {
+	if c.needsSnapshot(droplet) {
+		if err := c.snapshotDroplet(droplet); err != nil {
+			return fmt.Errorf("snapshot failed, droplet was not deleted: %v", err)
+		}
+	}
+
+	return c.deleteDroplet(droplet)
+}
+
+// expireSnapshots removes all snapshots taken by the cleaner, including the
+// ones of droplets that don't match the current prefixes (e.g. taken before
+// the configuration changed or by another cleaner using the same account)
+func (c *HangingDropletsCleaner) expireSnapshots() {
+	snapshotter, ok := c.client.(client.InstanceSnapshotter)
+	if !ok {
+		return
+	}
+
+	snapshots, err := snapshotter.ListSnapshots(SnapshotNamePrefix)
+	if err != nil {
+		logrus.Errorf("Error while listing snapshots: %v", err.Error())
+		return
+	}
+
+	for _, snapshot := range snapshots {
+		if time.Since(snapshot.CreatedAt) < c.snapshotRetention {
+			continue
+		}
+
+		logrus.Infof("Will delete expired snapshot: %s (created_at: %s)", snapshot.Name, snapshot.CreatedAt.Format(time.RFC3339))
+		if !c.delete {
+			continue
+		}
+
+		if err := snapshotter.DeleteSnapshot(snapshot); err != nil {
+			logrus.Errorf("Error while deleting snapshot '%s': %v", snapshot.Name, err.Error())
+			continue
+		}
+
+		atomic.AddInt64(&c.totalNumberOfExpiredSnapshots, 1)
+
+		snapshot := snapshot
+		c.audit(AuditEventSnapshotExpired, nil, &snapshot)
+	}
+}
@@ -0,0 +1,177 @@
+package cleaner
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+// DigitalOcean is known to respond with success for deletes that never
+// happen, so each deleted droplet is re-checked after a delay and deleted
+// again if it's still present. The check is done by the first cleanup pass
+// after the delay, so the pass itself never waits for it
+type deletionVerification struct {
+	droplet  client.Instance
+	failures int
+	dueAt    time.Time
+}
+
+func (c *HangingDropletsCleaner) EnableDeleteVerification(delay time.Duration, maxFailures int) {
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+
+	c.verifyDeletes = true
+	c.verifyDelay = delay
+	c.verifyMaxFailures = maxFailures
+}
+
+func (c *HangingDropletsCleaner) SetNotifier(notifier NotifierInterface) {
+	c.notifier = notifier
+}
+
+func (c *HangingDropletsCleaner) scheduleDeletionVerification(droplet client.Instance) {
+	if !c.verifyDeletes {
+		return
+	}
+
+	c.verificationsLock.Lock()
+	defer c.verificationsLock.Unlock()
+
+	c.pendingVerifications = append(c.pendingVerifications, &deletionVerification{
+		droplet: droplet,
+		dueAt:   time.Now().Add(c.verifyDelay),
+	})
+}
+
+func (c *HangingDropletsCleaner) escalateUndeletableDroplet(verification *deletionVerification) {
+	atomic.AddInt64(&c.totalNumberOfUndeletableDroplets, 1)
+
+	droplet := verification.droplet
+	message := fmt.Sprintf("Droplet '%s' (ID: %s) still exists after %d successful delete requests", droplet.Name, droplet.ID, verification.failures)
+	logrus.Errorln(message)
+
+	if c.notifier == nil {
+		return
+	}
+
+	err := c.notifier.Notify(Notification{
+		Event:   EventUndeletableDroplet,
+		Message: message,
+		Droplet: &droplet,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Error while sending notification about droplet '%s': %v", droplet.Name, err.Error())
+	}
+}
+
+// verifyDeletion returns true when the droplet was deleted again and needs
+// to be verified once more
+func (c *HangingDropletsCleaner) verifyDeletion(verification *deletionVerification) bool {
+	droplet := verification.droplet
+
+	exists, err := c.client.InstanceExists(droplet)
+	if err != nil {
+		logrus.Errorf("Error while verifying deletion of droplet '%s': %v", droplet.Name, err.Error())
+		return false
+	}
+
+	if !exists {
+		logrus.Debugf("Deletion of droplet '%s' verified", droplet.Name)
+		return false
+	}
+
+	atomic.AddInt64(&c.totalNumberOfPhantomDeletes, 1)
+	verification.failures++
+	logrus.Warnf("Droplet '%s' still exists after successful delete (%d/%d)", droplet.Name, verification.failures, c.verifyMaxFailures)
+
+	if verification.failures >= c.verifyMaxFailures {
+		c.escalateUndeletableDroplet(verification)
+		return false
+	}
+
+	logrus.Infof("Retrying deletion of droplet '%s'", droplet.Name)
+	if err := c.client.DeleteInstance(droplet); err != nil {
+		atomic.AddInt64(&c.totalNumberOfRemoveDropletErrors, 1)
+		logrus.Errorf("Error while deleting droplet '%s': %v", droplet.Name, err.Error())
+	}
+
+	return true
+}
+
+// verifyDeletions verifies the deletions that are due; droplets deleted
+// again are checked after another delay
+func (c *HangingDropletsCleaner) verifyDeletions() {
+	now := time.Now()
+
+	c.verificationsLock.Lock()
+	var due, notDue []*deletionVerification
+	for _, verification := range c.pendingVerifications {
+		if verification.dueAt.After(now) {
+			notDue = append(notDue, verification)
+		} else {
+			due = append(due, verification)
+		}
+	}
+	c.pendingVerifications = notDue
+	c.verificationsLock.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	logrus.Infof("Verifying deletion of %d droplets", len(due))
+
+	needsRetry := make([]bool, len(due))
+	c.runWorkers(len(due), func(i int) {
+		needsRetry[i] = c.verifyDeletion(due[i])
+	})
+
+	c.verificationsLock.Lock()
+	defer c.verificationsLock.Unlock()
+
+	for i, verification := range due {
+		if needsRetry[i] {
+			verification.dueAt = time.Now().Add(c.verifyDelay)
+			c.pendingVerifications = append(c.pendingVerifications, verification)
+		}
+	}
+}
+
+func (c *HangingDropletsCleaner) nextVerificationDue() (time.Time, bool) {
+	c.verificationsLock.Lock()
+	defer c.verificationsLock.Unlock()
+
+	var next time.Time
+	for _, verification := range c.pendingVerifications {
+		if next.IsZero() || verification.dueAt.Before(next) {
+			next = verification.dueAt
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// FinishDeletionVerifications waits for the pending verifications and runs
+// them until all deletions are verified. It's meant for the one-shot mode,
+// where there is no next pass to verify them
+func (c *HangingDropletsCleaner) FinishDeletionVerifications() {
+	for {
+		next, ok := c.nextVerificationDue()
+		if !ok {
+			return
+		}
+
+		if wait := next.Sub(time.Now()); wait > 0 {
+			logrus.Infof("Waiting %s before verifying deletion of droplets", wait)
+			time.Sleep(wait)
+		}
+
+		c.verifyDeletions()
+	}
+}
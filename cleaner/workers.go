@@ -0,0 +1,92 @@
+package cleaner
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+type dropletResult struct {
+	droplet   client.Instance
+	deleted   bool
+	stopErr   error
+	deleteErr error
+}
+
+type dropletResults []dropletResult
+
+func (r dropletResults) deleted() (count int) {
+	for _, result := range r {
+		if result.deleted {
+			count++
+		}
+	}
+
+	return
+}
+
+func (r dropletResults) failedNames(failed func(dropletResult) bool) (names []string) {
+	for _, result := range r {
+		if failed(result) {
+			names = append(names, result.droplet.Name)
+		}
+	}
+
+	return
+}
+
+func (r dropletResults) logSummary() {
+	logrus.Infof("Finished droplets cleanup. Removed %d droplets", r.deleted())
+
+	stopFailures := r.failedNames(func(result dropletResult) bool { return result.stopErr != nil })
+	if len(stopFailures) > 0 {
+		logrus.Warnf("Failed to stop %d droplets: %s", len(stopFailures), strings.Join(stopFailures, ", "))
+	}
+
+	deleteFailures := r.failedNames(func(result dropletResult) bool { return result.deleteErr != nil })
+	if len(deleteFailures) > 0 {
+		logrus.Warnf("Failed to delete %d droplets: %s", len(deleteFailures), strings.Join(deleteFailures, ", "))
+	}
+}
+
+// runWorkers calls fn for each index in [0, count) using at most
+// c.concurrency goroutines and returns when all calls are finished
+func (c *HangingDropletsCleaner) runWorkers(count int, fn func(i int)) {
+	workers := c.concurrency
+	if workers > count {
+		workers = count
+	}
+
+	jobs := make(chan int)
+	wg := new(sync.WaitGroup)
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+func (c *HangingDropletsCleaner) processDroplets(droplets []client.Instance, fn func(client.Instance) dropletResult) dropletResults {
+	results := make(dropletResults, len(droplets))
+	c.runWorkers(len(droplets), func(i int) {
+		results[i] = fn(droplets[i])
+	})
+
+	return results
+}
@@ -0,0 +1,144 @@
+package cleaner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+const (
+	DefaultZombieFolderMinAge           = 1 * time.Hour
+	DefaultZombieFolderArchiveRetention = 7 * 24 * time.Hour
+)
+
+// SetZombieFolderPolicy configures removal of machine folders without
+// droplets. Folders younger than minAge are kept. If archiveDirectory is not
+// empty, folders are moved there instead of being deleted, and removed from
+// the archive after the retention
+func (c *HangingDropletsCleaner) SetZombieFolderPolicy(minAge time.Duration, archiveDirectory string, archiveRetention time.Duration) {
+	c.zombieFolderMinAge = minAge
+	c.zombieFolderArchiveDirectory = archiveDirectory
+	c.zombieFolderArchiveRetention = archiveRetention
+}
+
+func machineDriverName(machine Machine) string {
+	if machine.Driver == "" {
+		return defaultDriverName
+	}
+
+	return machine.Driver
+}
+
+// isZombieFolder checks if the machine's folder can be removed. Only local
+// folders of complete machines, created by the driver of the used provider,
+// older than the minimal age and whose instance is confirmed to not exist
+// are treated as zombies
+func (c *HangingDropletsCleaner) isZombieFolder(machine Machine, dropletNames []string, dropletIDs []string) bool {
+	if machine.Inventory != "" {
+		return false
+	}
+
+	if machine.State != MachineStateComplete || machine.InstanceID == "" {
+		return false
+	}
+
+	if machineDriverName(machine) != c.client.Name() {
+		return false
+	}
+
+	if c.stringInSlice(machine.InstanceID, dropletIDs) || c.stringInSlice(machine.Name, dropletNames) {
+		return false
+	}
+
+	if machine.CreatedAt.IsZero() || time.Since(machine.CreatedAt) < c.zombieFolderMinAge {
+		logrus.Debugf("Machine folder of %s is younger than %s; skipping", machine.Name, c.zombieFolderMinAge)
+		return false
+	}
+
+	// the listing may be incomplete or not up to date, so the absence of
+	// the instance is confirmed directly
+	exists, err := c.client.InstanceExists(client.Instance{ID: machine.InstanceID, Name: machine.Name})
+	if err != nil {
+		logrus.Errorf("Error while checking droplet of machine %s: %v", machine.Name, err.Error())
+		return false
+	}
+
+	return !exists
+}
+
+func (c *HangingDropletsCleaner) expireArchivedFolders() {
+	if c.zombieFolderArchiveDirectory == "" {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(c.zombieFolderArchiveDirectory)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Error while listing archived machine folders: %v", err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		archivedAt, ok := archivedMachineTime(entry.Name())
+		if !ok {
+			archivedAt = entry.ModTime()
+		}
+
+		if time.Since(archivedAt) < c.zombieFolderArchiveRetention {
+			continue
+		}
+
+		path := filepath.Join(c.zombieFolderArchiveDirectory, entry.Name())
+		logrus.Infof("Removing expired archived machine folder: %s", path)
+
+		if err := os.RemoveAll(path); err != nil {
+			logrus.Errorf("Error while removing archived machine folder %s: %v", path, err.Error())
+		}
+	}
+}
+
+func (c *HangingDropletsCleaner) findAndDeleteZombieFolders(droplets []client.Instance, machines []Machine) {
+	var dropletNames, dropletIDs []string
+	for _, droplet := range droplets {
+		dropletNames = append(dropletNames, droplet.Name)
+		dropletIDs = append(dropletIDs, droplet.ID)
+	}
+
+	logrus.Infof("Got %d droplets to sync with folders", len(dropletNames))
+
+	for _, machine := range machines {
+		if !c.isZombieFolder(machine, dropletNames, dropletIDs) {
+			continue
+		}
+
+		logrus.Infof("Going to clean machine folder of %s", machine.Name)
+		if !c.delete {
+			continue
+		}
+
+		if c.zombieFolderArchiveDirectory == "" {
+			if c.cleanDockerMachineFolder(machine) == nil {
+				atomic.AddInt64(&c.totalNumberOfZombieFolders, 1)
+			}
+			continue
+		}
+
+		if err := c.machineStore.ArchiveMachine(machine, c.zombieFolderArchiveDirectory); err != nil {
+			logrus.Errorf("Error while archiving machine folder of %s: %v", machine.Name, err.Error())
+			continue
+		}
+		atomic.AddInt64(&c.totalNumberOfZombieFolders, 1)
+	}
+
+	if c.delete {
+		c.expireArchivedFolders()
+	}
+}
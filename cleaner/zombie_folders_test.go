@@ -0,0 +1,223 @@
+package cleaner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+// registerFakeDriver makes the machines of the "fake" driver, used by
+// FakeDOClient, readable like the DigitalOcean ones
+func registerFakeDriver() func() {
+	driverDetailsReaders["fake"] = digitalOceanDriverDetails
+
+	return func() {
+		delete(driverDetailsReaders, "fake")
+	}
+}
+
+func TestZombieFolders(t *testing.T) {
+	defer registerFakeDriver()()
+
+	examples := map[string]struct {
+		config          string
+		age             time.Duration
+		dropletExists   bool
+		archive         bool
+		expectedRemoved bool
+	}{
+		"zombie folder": {
+			config:          `{"DriverName": "fake", "Driver": {"DropletID": 10}}`,
+			age:             2 * time.Hour,
+			expectedRemoved: true,
+		},
+		"archived zombie folder": {
+			config:          `{"DriverName": "fake", "Driver": {"DropletID": 10}}`,
+			age:             2 * time.Hour,
+			archive:         true,
+			expectedRemoved: true,
+		},
+		"young folder": {
+			config: `{"DriverName": "fake", "Driver": {"DropletID": 10}}`,
+			age:    time.Minute,
+		},
+		"folder without droplet ID": {
+			config: `{"DriverName": "fake", "Driver": {}}`,
+			age:    2 * time.Hour,
+		},
+		"folder of other driver": {
+			config: `{"DriverName": "amazonec2", "Driver": {"InstanceId": "i-10"}}`,
+			age:    2 * time.Hour,
+		},
+		"droplet missing from the list, but existing": {
+			config:        `{"DriverName": "fake", "Driver": {"DropletID": 10}}`,
+			age:           2 * time.Hour,
+			dropletExists: true,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "zombies")
+			require.NoError(t, err)
+			defer os.RemoveAll(directory)
+
+			machinesDirectory := filepath.Join(directory, "machines")
+			archiveDirectory := filepath.Join(directory, "archive")
+			createMachineConfig(t, machinesDirectory, "runner-abc123-zombie", example.config)
+
+			cleaner, doClient, machinesFinder := getCleaner(t)
+			cleaner.EnableDelete()
+			cleaner.SetMachineStore(NewDockerMachineStore(machinesDirectory, doClient))
+			if example.archive {
+				cleaner.SetZombieFolderPolicy(time.Hour, archiveDirectory, time.Hour)
+			}
+
+			doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+				return []client.Instance{
+					{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now().Add(-1 * time.Hour)},
+				}, nil
+			}
+
+			machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+				machine := readMachine(machinesDirectory, "runner-abc123-zombie")
+				machine.CreatedAt = time.Now().Add(-example.age)
+
+				return []Machine{
+					{Name: "runner-abc123-test-1", InstanceID: "1", State: MachineStateComplete},
+					machine,
+				}, nil
+			}
+
+			doClient.dropletExistsAsserts = func(c *FakeDOClient, droplet client.Instance) (bool, error) {
+				assert.Equal(t, "10", droplet.ID)
+				return example.dropletExists, nil
+			}
+
+			err = cleaner.Clean()
+			assert.NoError(t, err)
+
+			_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-zombie"))
+			if !example.expectedRemoved {
+				assert.NoError(t, err, "Should keep the machine folder")
+				return
+			}
+
+			assert.True(t, os.IsNotExist(err), "Should remove the machine folder")
+			assert.Equal(t, int64(1), cleaner.totalNumberOfZombieFolders)
+
+			archived, _ := filepath.Glob(filepath.Join(archiveDirectory, "*-runner-abc123-zombie"))
+			if example.archive {
+				assert.Len(t, archived, 1, "Should move the machine folder to the archive")
+			} else {
+				assert.Empty(t, archived)
+			}
+		})
+	}
+}
+
+func TestExpiredArchivedFoldersAreRemoved(t *testing.T) {
+	archiveDirectory, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(archiveDirectory)
+
+	oldFolder := filepath.Join(archiveDirectory, archivedMachineName("runner-abc123-old", time.Now().Add(-2*time.Hour)))
+	newFolder := filepath.Join(archiveDirectory, archivedMachineName("runner-abc123-new", time.Now()))
+	unknownFolder := filepath.Join(archiveDirectory, "runner-abc123-unknown")
+	require.NoError(t, os.Mkdir(oldFolder, 0700))
+	require.NoError(t, os.Mkdir(newFolder, 0700))
+	require.NoError(t, os.Mkdir(unknownFolder, 0700))
+	// the modification time of archived folders is the one from before
+	// archiving, so it's used only for folders without the archive time
+	require.NoError(t, os.Chtimes(newFolder, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+	require.NoError(t, os.Chtimes(unknownFolder, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+
+	cleaner, _, _ := getCleaner(t)
+	cleaner.SetZombieFolderPolicy(time.Hour, archiveDirectory, time.Hour)
+	cleaner.expireArchivedFolders()
+
+	_, err = os.Stat(oldFolder)
+	assert.True(t, os.IsNotExist(err), "Should remove expired folder")
+	_, err = os.Stat(newFolder)
+	assert.NoError(t, err, "Should keep folder within retention")
+	_, err = os.Stat(unknownFolder)
+	assert.True(t, os.IsNotExist(err), "Should remove expired folder without the archive time")
+}
+
+func TestOldZombieFolderIsKeptInArchive(t *testing.T) {
+	defer registerFakeDriver()()
+
+	directory, err := ioutil.TempDir("", "zombies")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	machinesDirectory := filepath.Join(directory, "machines")
+	archiveDirectory := filepath.Join(directory, "archive")
+	createMachineConfig(t, machinesDirectory, "runner-abc123-zombie", `{"DriverName": "fake", "Driver": {"DropletID": 10}}`)
+
+	folder := filepath.Join(machinesDirectory, "runner-abc123-zombie")
+	modTime := time.Now().Add(-8 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(folder, "config.json"), modTime, modTime))
+	require.NoError(t, os.Chtimes(folder, modTime, modTime))
+
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.SetMachineStore(NewDockerMachineStore(machinesDirectory, doClient))
+	cleaner.SetZombieFolderPolicy(time.Hour, archiveDirectory, 7*24*time.Hour)
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		return []Machine{readMachine(machinesDirectory, "runner-abc123-zombie")}, nil
+	}
+
+	require.NoError(t, cleaner.Clean())
+
+	_, err = os.Stat(folder)
+	assert.True(t, os.IsNotExist(err), "Should move the machine folder")
+
+	archived, _ := filepath.Glob(filepath.Join(archiveDirectory, "*-runner-abc123-zombie"))
+	assert.Len(t, archived, 1, "Should keep the archived folder for the retention")
+}
+
+func TestZombieFoldersAreCleanedWithoutOldDroplets(t *testing.T) {
+	defer registerFakeDriver()()
+
+	directory, err := ioutil.TempDir("", "zombies")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	machinesDirectory := filepath.Join(directory, "machines")
+	createMachineConfig(t, machinesDirectory, "runner-abc123-zombie", `{"DriverName": "fake", "Driver": {"DropletID": 10}}`)
+
+	cleaner, doClient, machinesFinder := getCleaner(t)
+	cleaner.EnableDelete()
+	cleaner.SetMachineStore(NewDockerMachineStore(machinesDirectory, doClient))
+
+	doClient.listDropletsAsserts = func(c *FakeDOClient) ([]client.Instance, error) {
+		return []client.Instance{
+			{ID: "1", Name: "runner-abc123-test-1", CreatedAt: time.Now()},
+		}, nil
+	}
+
+	machinesFinder.listMachinesAsserts = func(*FakeMachinesFinder) ([]Machine, error) {
+		machine := readMachine(machinesDirectory, "runner-abc123-zombie")
+		machine.CreatedAt = time.Now().Add(-2 * time.Hour)
+
+		return []Machine{machine}, nil
+	}
+
+	require.NoError(t, cleaner.Clean())
+
+	_, err = os.Stat(filepath.Join(machinesDirectory, "runner-abc123-zombie"))
+	assert.True(t, os.IsNotExist(err), "Should remove the machine folder")
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const AmazonEC2ProviderName = "amazonec2"
+
+// Instances in these states are still billed (or will be in a moment), so
+// they are the only ones worth looking at
+var amazonEC2ListedStates = []string{
+	ec2.InstanceStateNamePending,
+	ec2.InstanceStateNameRunning,
+	ec2.InstanceStateNameStopping,
+	ec2.InstanceStateNameStopped,
+}
+
+// filter values treat `*` and `?` as wildcards
+var amazonEC2FilterEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`)
+
+type AmazonEC2Config struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+	Endpoint  string
+}
+
+type AmazonEC2Client struct {
+	client ec2iface.EC2API
+}
+
+func (c *AmazonEC2Client) Name() string {
+	return AmazonEC2ProviderName
+}
+
+func (c *AmazonEC2Client) ec2InstanceToInstance(ec2Instance *ec2.Instance) Instance {
+	instance := Instance{
+		ID:        aws.StringValue(ec2Instance.InstanceId),
+		Size:      aws.StringValue(ec2Instance.InstanceType),
+		CreatedAt: aws.TimeValue(ec2Instance.LaunchTime),
+		Provider:  AmazonEC2ProviderName,
+	}
+
+	if ec2Instance.Placement != nil {
+		instance.Region = aws.StringValue(ec2Instance.Placement.AvailabilityZone)
+	}
+
+	if ec2Instance.State != nil {
+		instance.Status = aws.StringValue(ec2Instance.State.Name)
+	}
+
+	for _, tag := range ec2Instance.Tags {
+		key := aws.StringValue(tag.Key)
+		if key == "Name" {
+			instance.Name = aws.StringValue(tag.Value)
+			continue
+		}
+
+		value := aws.StringValue(tag.Value)
+		if value != "" {
+			key = key + ":" + value
+		}
+
+		instance.Tags = append(instance.Tags, key)
+	}
+
+	return instance
+}
+
+// amazonEC2NameFilter narrows the listing to instances with names starting
+// with the prefixes; names are still matched against the regexp afterwards
+func amazonEC2NameFilter(instancesPrefixRegexp *regexp.Regexp) *ec2.Filter {
+	prefixes := namePrefixes(instancesPrefixRegexp)
+	if len(prefixes) < 1 {
+		return &ec2.Filter{
+			Name:   aws.String("tag-key"),
+			Values: aws.StringSlice([]string{"Name"}),
+		}
+	}
+
+	var values []string
+	for _, prefix := range prefixes {
+		values = append(values, amazonEC2FilterEscaper.Replace(prefix)+"*")
+	}
+
+	return &ec2.Filter{
+		Name:   aws.String("tag:Name"),
+		Values: aws.StringSlice(values),
+	}
+}
+
+func (c *AmazonEC2Client) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			amazonEC2NameFilter(instancesPrefixRegexp),
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice(amazonEC2ListedStates),
+			},
+		},
+	}
+
+	err = c.client.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		var instancesList []Instance
+		for _, reservation := range page.Reservations {
+			for _, ec2Instance := range reservation.Instances {
+				instancesList = append(instancesList, c.ec2InstanceToInstance(ec2Instance))
+			}
+		}
+
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		return true
+	})
+
+	return
+}
+
+func (c *AmazonEC2Client) StopInstance(instance Instance) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancelFn()
+
+	_, err := c.client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instance.ID}),
+		Force:       aws.Bool(true),
+	})
+
+	return err
+}
+
+func (c *AmazonEC2Client) DeleteInstance(instance Instance) error {
+	_, err := c.client.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instance.ID}),
+	})
+
+	return err
+}
+
+func (c *AmazonEC2Client) InstanceExists(instance Instance) (bool, error) {
+	output, err := c.client.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instance.ID}),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidInstanceID.NotFound" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, reservation := range output.Reservations {
+		for _, ec2Instance := range reservation.Instances {
+			if ec2Instance.State == nil {
+				return true, nil
+			}
+
+			switch aws.StringValue(ec2Instance.State.Name) {
+			case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+				continue
+			}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func NewAmazonEC2Client(config AmazonEC2Config) (*AmazonEC2Client, error) {
+	awsConfig := aws.NewConfig().WithRegion(config.Region)
+	if config.AccessKey != "" || config.SecretKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, ""))
+	}
+
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := ec2.New(sess)
+	client.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(version.AppVersion.UserAgent()))
+
+	return &AmazonEC2Client{
+		client: client,
+	}, nil
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ec2StubInstance struct {
+	ID         string
+	Name       string
+	LaunchTime time.Time
+}
+
+type ec2StubServer struct {
+	*httptest.Server
+
+	t *testing.T
+
+	instances  []ec2StubInstance
+	filters    map[string][]string
+	stopped    []string
+	terminated []string
+}
+
+func ec2StubFilters(r *http.Request) map[string][]string {
+	filters := make(map[string][]string)
+	for i := 1; r.FormValue(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		name := r.FormValue(fmt.Sprintf("Filter.%d.Name", i))
+		for j := 1; r.FormValue(fmt.Sprintf("Filter.%d.Value.%d", i, j)) != ""; j++ {
+			filters[name] = append(filters[name], r.FormValue(fmt.Sprintf("Filter.%d.Value.%d", i, j)))
+		}
+	}
+
+	return filters
+}
+
+func (s *ec2StubServer) writeInstance(w http.ResponseWriter, instance ec2StubInstance) {
+	fmt.Fprintf(w, `<item>
+  <instanceId>%s</instanceId>
+  <instanceType>t2.micro</instanceType>
+  <launchTime>%s</launchTime>
+  <instanceState><code>16</code><name>running</name></instanceState>
+  <placement><availabilityZone>us-east-1a</availabilityZone></placement>
+  <tagSet>
+    <item><key>Name</key><value>%s</value></item>
+    <item><key>keep</key><value></value></item>
+  </tagSet>
+</item>`, instance.ID, instance.LaunchTime.UTC().Format(time.RFC3339), instance.Name)
+}
+
+func (s *ec2StubServer) writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code><Message>stub error</Message></Error></Errors><RequestID>stub</RequestID></Response>`, code)
+}
+
+// describeInstance answers DescribeInstances called with an instance ID;
+// the i-forbidden instance can't be described
+func (s *ec2StubServer) describeInstance(w http.ResponseWriter, id string) {
+	if id == "i-forbidden" {
+		s.writeError(w, http.StatusForbidden, "UnauthorizedOperation")
+		return
+	}
+
+	for _, instance := range s.instances {
+		if instance.ID != id {
+			continue
+		}
+
+		fmt.Fprintln(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">`)
+		fmt.Fprintln(w, `<requestId>stub</requestId><reservationSet><item><reservationId>r-stub</reservationId><instancesSet>`)
+		s.writeInstance(w, instance)
+		fmt.Fprintln(w, `</instancesSet></item></reservationSet></DescribeInstancesResponse>`)
+		return
+	}
+
+	s.writeError(w, http.StatusBadRequest, "InvalidInstanceID.NotFound")
+}
+
+// describeInstances returns one instance per page to exercise the pagination
+func (s *ec2StubServer) describeInstances(w http.ResponseWriter, r *http.Request) {
+	if id := r.FormValue("InstanceId.1"); id != "" {
+		s.describeInstance(w, id)
+		return
+	}
+
+	s.filters = ec2StubFilters(r)
+
+	index := 0
+	fmt.Sscanf(r.FormValue("NextToken"), "page-%d", &index)
+
+	fmt.Fprintln(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">`)
+	fmt.Fprintln(w, `<requestId>stub</requestId><reservationSet>`)
+	if index < len(s.instances) {
+		fmt.Fprintln(w, `<item><reservationId>r-stub</reservationId><instancesSet>`)
+		s.writeInstance(w, s.instances[index])
+		fmt.Fprintln(w, `</instancesSet></item>`)
+	}
+	fmt.Fprintln(w, `</reservationSet>`)
+	if index+1 < len(s.instances) {
+		fmt.Fprintf(w, "<nextToken>page-%d</nextToken>\n", index+1)
+	}
+	fmt.Fprintln(w, `</DescribeInstancesResponse>`)
+}
+
+func (s *ec2StubServer) handle(w http.ResponseWriter, r *http.Request) {
+	// require would stop only the handler's goroutine, not the test
+	if !assert.NoError(s.t, r.ParseForm()) {
+		s.writeError(w, http.StatusBadRequest, "MalformedQueryString")
+		return
+	}
+
+	switch r.FormValue("Action") {
+	case "DescribeInstances":
+		s.describeInstances(w, r)
+	case "StopInstances":
+		s.stopped = append(s.stopped, r.FormValue("InstanceId.1"))
+		fmt.Fprintln(w, `<StopInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>stub</requestId></StopInstancesResponse>`)
+	case "TerminateInstances":
+		s.terminated = append(s.terminated, r.FormValue("InstanceId.1"))
+		fmt.Fprintln(w, `<TerminateInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>stub</requestId></TerminateInstancesResponse>`)
+	default:
+		s.writeError(w, http.StatusBadRequest, "InvalidAction")
+	}
+}
+
+func newEC2StubServer(t *testing.T, instances []ec2StubInstance) (*ec2StubServer, *AmazonEC2Client) {
+	stub := &ec2StubServer{t: t, instances: instances}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.handle))
+
+	client, err := NewAmazonEC2Client(AmazonEC2Config{
+		AccessKey: "access-key",
+		SecretKey: "secret-key",
+		Region:    "us-east-1",
+		Endpoint:  stub.URL,
+	})
+	require.NoError(t, err)
+
+	return stub, client
+}
+
+func TestAmazonEC2ListInstances(t *testing.T) {
+	stub, client := newEC2StubServer(t, []ec2StubInstance{
+		{ID: "i-1", Name: "runner-abc123-test-1", LaunchTime: time.Now().Add(-time.Hour)},
+		{ID: "i-2", Name: "runner-abc123-test-2", LaunchTime: time.Now()},
+		{ID: "i-3", Name: "other-machine", LaunchTime: time.Now().Add(-time.Hour)},
+	})
+	defer stub.Close()
+
+	instances, err := client.ListInstances(regexp.MustCompile("^runner-abc123"), 10*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, instances, 1, "Should list only old enough instances matching the prefix")
+
+	instance := instances[0]
+	assert.Equal(t, "i-1", instance.ID)
+	assert.Equal(t, "runner-abc123-test-1", instance.Name)
+	assert.Equal(t, "us-east-1a", instance.Region)
+	assert.Equal(t, "t2.micro", instance.Size)
+	assert.Equal(t, "running", instance.Status)
+	assert.Equal(t, []string{"keep"}, instance.Tags)
+	assert.Equal(t, AmazonEC2ProviderName, instance.Provider)
+}
+
+func TestAmazonEC2ListInstancesFiltersByName(t *testing.T) {
+	stub, client := newEC2StubServer(t, nil)
+	defer stub.Close()
+
+	_, err := client.ListInstances(regexp.MustCompile("^(runner-abc123|other-def*)"), 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runner-abc123*", "other-de*"}, stub.filters["tag:Name"], "Should filter by name on the server side")
+
+	_, err = client.ListInstances(regexp.MustCompile("^([a-z]+-runner)"), 10*time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, stub.filters["tag:Name"])
+	assert.Equal(t, []string{"Name"}, stub.filters["tag-key"], "Should list all named instances when prefixes are unknown")
+}
+
+func TestAmazonEC2StopAndDeleteInstance(t *testing.T) {
+	stub, client := newEC2StubServer(t, nil)
+	defer stub.Close()
+
+	instance := Instance{ID: "i-1", Name: "runner-abc123-test-1"}
+
+	assert.NoError(t, client.StopInstance(instance))
+	assert.NoError(t, client.DeleteInstance(instance))
+	assert.Equal(t, []string{"i-1"}, stub.stopped)
+	assert.Equal(t, []string{"i-1"}, stub.terminated)
+}
+
+func TestAmazonEC2InstanceExists(t *testing.T) {
+	stub, client := newEC2StubServer(t, []ec2StubInstance{
+		{ID: "i-1", Name: "runner-abc123-test-1", LaunchTime: time.Now()},
+	})
+	defer stub.Close()
+
+	testInstanceExists(t, client, "i-1", "i-2", "i-forbidden")
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"golang.org/x/oauth2"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const (
+	DigitalOceanProviderName = "digitalocean"
+
+	DefaultDigitalOceanActionTimeout = 2 * time.Minute
+	digitalOceanActionPollInterval   = 5 * time.Second
+	digitalOceanRequestTimeout       = 1 * time.Minute
+)
+
+type DigitalOceanConfig struct {
+	Token            string
+	ActionTimeout    time.Duration
+	RateLimitReserve int
+	MaxRetries       int
+	Tags             []string
+	SnapshotTimeout  time.Duration
+}
+
+type tokenSource struct {
+	accessToken string
+}
+
+func (t *tokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{
+		AccessToken: t.accessToken,
+	}, nil
+}
+
+type DigitalOceanClient struct {
+	client  *godo.Client
+	limiter *digitalOceanRateLimiter
+	tags    []string
+
+	actionTimeout      time.Duration
+	actionPollInterval time.Duration
+	snapshotTimeout    time.Duration
+}
+
+func (c *DigitalOceanClient) Name() string {
+	return DigitalOceanProviderName
+}
+
+func (c *DigitalOceanClient) Describe(ch chan<- *prometheus.Desc) {
+	c.limiter.Describe(ch)
+}
+
+func (c *DigitalOceanClient) Collect(ch chan<- prometheus.Metric) {
+	c.limiter.Collect(ch)
+}
+
+func (c *DigitalOceanClient) dropletToInstance(droplet godo.Droplet) Instance {
+	instance := Instance{
+		ID:       strconv.Itoa(droplet.ID),
+		Name:     droplet.Name,
+		Tags:     droplet.Tags,
+		Status:   droplet.Status,
+		Provider: DigitalOceanProviderName,
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, droplet.Created); err == nil {
+		instance.CreatedAt = createdAt
+	}
+
+	if droplet.Region != nil {
+		instance.Region = droplet.Region.Slug
+	}
+
+	instance.Size = droplet.SizeSlug
+	if instance.Size == "" && droplet.Size != nil {
+		instance.Size = droplet.Size.Slug
+	}
+
+	return instance
+}
+
+func (c *DigitalOceanClient) dropletID(instance Instance) (int, error) {
+	return strconv.Atoi(instance.ID)
+}
+
+type dropletsListFunc func(ctx context.Context, opts *godo.ListOptions) ([]godo.Droplet, *godo.Response, error)
+
+func (c *DigitalOceanClient) listDropletsPage(list dropletsListFunc, dropletsPrefixRegexp *regexp.Regexp, dropletAge time.Duration, pageOpts *godo.ListOptions) (instances []Instance, readNext bool, err error) {
+	readNext = false
+
+	var dropletsList []godo.Droplet
+	resp, err := c.limiter.Do(context.Background(), func(ctx context.Context) (resp *godo.Response, err error) {
+		dropletsList, resp, err = list(ctx, pageOpts)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	var instancesList []Instance
+	for _, droplet := range dropletsList {
+		instancesList = append(instancesList, c.dropletToInstance(droplet))
+	}
+
+	instances = selectInstances(dropletsPrefixRegexp, dropletAge, instancesList)
+
+	if resp.Links == nil || resp.Links.IsLastPage() {
+		return
+	}
+
+	page, err := resp.Links.CurrentPage()
+	if err != nil {
+		return
+	}
+
+	pageOpts.Page = page + 1
+	readNext = true
+
+	return
+}
+
+func (c *DigitalOceanClient) listDroplets(list dropletsListFunc, dropletsPrefixRegexp *regexp.Regexp, dropletAge time.Duration) (instances []Instance, err error) {
+	pageOpts := &godo.ListOptions{
+		Page:    1,
+		PerPage: 250,
+	}
+
+	var selectedInstances []Instance
+	var readNext bool
+	for {
+		selectedInstances, readNext, err = c.listDropletsPage(list, dropletsPrefixRegexp, dropletAge, pageOpts)
+		if err != nil {
+			return
+		}
+
+		instances = append(instances, selectedInstances...)
+
+		if !readNext {
+			break
+		}
+	}
+
+	return
+}
+
+// ListInstances scans the whole account, unless tags are configured. In that
+// case only droplets having at least one of the tags are listed (server side)
+// and the prefix regexp is applied on top of that
+func (c *DigitalOceanClient) ListInstances(dropletsPrefixRegexp *regexp.Regexp, dropletAge time.Duration) (instances []Instance, err error) {
+	if len(c.tags) < 1 {
+		return c.listDroplets(c.client.Droplets.List, dropletsPrefixRegexp, dropletAge)
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range c.tags {
+		tag := tag
+		list := func(ctx context.Context, opts *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+			return c.client.Droplets.ListByTag(ctx, tag, opts)
+		}
+
+		var tagged []Instance
+		tagged, err = c.listDroplets(list, dropletsPrefixRegexp, dropletAge)
+		if err != nil {
+			return
+		}
+
+		for _, instance := range tagged {
+			if seen[instance.ID] {
+				continue
+			}
+
+			seen[instance.ID] = true
+			instances = append(instances, instance)
+		}
+	}
+
+	return
+}
+
+func (c *DigitalOceanClient) waitForAction(ctx context.Context, instance Instance, dropletID int, action *godo.Action) error {
+	for {
+		switch action.Status {
+		case godo.ActionCompleted:
+			return nil
+		case "errored":
+			return &ActionError{
+				Action:     action.Type,
+				InstanceID: instance.ID,
+				Status:     action.Status,
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return &ActionError{
+				Action:     action.Type,
+				InstanceID: instance.ID,
+				Status:     action.Status,
+				Timeout:    true,
+			}
+		case <-time.After(c.actionPollInterval):
+		}
+
+		var current *godo.Action
+		_, err := c.limiter.Do(ctx, func(ctx context.Context) (resp *godo.Response, err error) {
+			current, resp, err = c.client.DropletActions.Get(ctx, dropletID, action.ID)
+			return
+		})
+		if err != nil {
+			// a request interrupted by the deadline is reported as a timeout
+			if ctx.Err() != nil {
+				continue
+			}
+			return err
+		}
+
+		action = current
+	}
+}
+
+// StopInstance powers off the droplet and waits until DigitalOcean reports
+// the action as finished, so the droplet can be safely deleted afterwards
+func (c *DigitalOceanClient) StopInstance(instance Instance) error {
+	id, err := c.dropletID(instance)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.actionTimeout)
+	defer cancelFn()
+
+	var action *godo.Action
+	_, err = c.limiter.DoOnce(ctx, func(ctx context.Context) (resp *godo.Response, err error) {
+		action, resp, err = c.client.DropletActions.PowerOff(ctx, id)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForAction(ctx, instance, id, action)
+}
+
+func (c *DigitalOceanClient) DeleteInstance(instance Instance) error {
+	id, err := c.dropletID(instance)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.limiter.Do(context.Background(), func(ctx context.Context) (*godo.Response, error) {
+		return c.client.Droplets.Delete(ctx, id)
+	})
+	// retried request finds the droplet already deleted by the failed one
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (c *DigitalOceanClient) InstanceExists(instance Instance) (bool, error) {
+	id, err := c.dropletID(instance)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.limiter.Do(context.Background(), func(ctx context.Context) (resp *godo.Response, err error) {
+		_, resp, err = c.client.Droplets.Get(ctx, id)
+		return
+	})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (c *DigitalOceanClient) TagInstance(instance Instance, tag string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.actionTimeout)
+	defer cancelFn()
+
+	// tagging droplet with a tag that doesn't exist fails, so the tag is
+	// created first; creating an existing tag is a no-op
+	_, err := c.limiter.Do(ctx, func(ctx context.Context) (resp *godo.Response, err error) {
+		_, resp, err = c.client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag})
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.limiter.Do(ctx, func(ctx context.Context) (*godo.Response, error) {
+		return c.client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{
+			Resources: []godo.Resource{
+				{ID: instance.ID, Type: godo.DropletResourceType},
+			},
+		})
+	})
+
+	return err
+}
+
+func (c *DigitalOceanClient) UntagInstance(instance Instance, tag string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.actionTimeout)
+	defer cancelFn()
+
+	_, err := c.limiter.Do(ctx, func(ctx context.Context) (*godo.Response, error) {
+		return c.client.Tags.UntagResources(ctx, tag, &godo.UntagResourcesRequest{
+			Resources: []godo.Resource{
+				{ID: instance.ID, Type: godo.DropletResourceType},
+			},
+		})
+	})
+
+	return err
+}
+
+// RemoveSSHKey removes the SSH key registered by Docker Machine's
+// DigitalOcean driver; a key that doesn't exist anymore is not an error
+func (c *DigitalOceanClient) RemoveSSHKey(id string) error {
+	keyID, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.limiter.Do(context.Background(), func(ctx context.Context) (*godo.Response, error) {
+		return c.client.Keys.DeleteByID(ctx, keyID)
+	})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func NewDigitalOceanClient(config DigitalOceanConfig) *DigitalOceanClient {
+	ts := &tokenSource{accessToken: config.Token}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Timeout: digitalOceanRequestTimeout,
+	})
+	client := godo.NewClient(oauth2.NewClient(ctx, ts))
+	client.UserAgent = version.AppVersion.UserAgent()
+
+	actionTimeout := config.ActionTimeout
+	if actionTimeout <= 0 {
+		actionTimeout = DefaultDigitalOceanActionTimeout
+	}
+
+	snapshotTimeout := config.SnapshotTimeout
+	if snapshotTimeout <= 0 {
+		snapshotTimeout = DefaultDigitalOceanSnapshotTimeout
+	}
+
+	return &DigitalOceanClient{
+		client:             client,
+		limiter:            newDigitalOceanRateLimiter(config.RateLimitReserve, config.MaxRetries),
+		tags:               config.Tags,
+		actionTimeout:      actionTimeout,
+		snapshotTimeout:    snapshotTimeout,
+		actionPollInterval: digitalOceanActionPollInterval,
+	}
+}
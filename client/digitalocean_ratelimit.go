@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client/internal/rest"
+)
+
+const (
+	DefaultDigitalOceanRateLimitReserve = 500
+	DefaultDigitalOceanMaxRetries       = 5
+
+	digitalOceanRetryBaseDelay = 1 * time.Second
+)
+
+var (
+	digitalOceanRateLimit = prometheus.NewDesc(
+		"hanging_droplets_cleaner_digitalocean_rate_limit",
+		"Number of DigitalOcean API requests allowed per hour",
+		[]string{},
+		nil,
+	)
+
+	digitalOceanRateLimitRemaining = prometheus.NewDesc(
+		"hanging_droplets_cleaner_digitalocean_rate_limit_remaining",
+		"Number of DigitalOcean API requests remaining in the current rate limit window",
+		[]string{},
+		nil,
+	)
+
+	digitalOceanAPIRetries = prometheus.NewDesc(
+		"hanging_droplets_cleaner_digitalocean_api_retries_total",
+		"Total number of retried DigitalOcean API requests",
+		[]string{},
+		nil,
+	)
+
+	digitalOceanThrottles = prometheus.NewDesc(
+		"hanging_droplets_cleaner_digitalocean_throttles_total",
+		"Total number of times the cleaner waited for the DigitalOcean rate limit reset",
+		[]string{},
+		nil,
+	)
+)
+
+// digitalOceanRateLimiter tracks the API budget reported by DigitalOcean
+// (which is shared by all users of the token, e.g. GitLab Runner itself),
+// waits for the reset when the budget goes below the reserve, and retries
+// throttled and failed requests with jittered exponential backoff
+type digitalOceanRateLimiter struct {
+	lock sync.Mutex
+	rate godo.Rate
+
+	reserve    int
+	maxRetries int
+	baseDelay  time.Duration
+
+	totalRetries   int64
+	totalThrottles int64
+}
+
+func (l *digitalOceanRateLimiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- digitalOceanRateLimit
+	ch <- digitalOceanRateLimitRemaining
+	ch <- digitalOceanAPIRetries
+	ch <- digitalOceanThrottles
+}
+
+func (l *digitalOceanRateLimiter) Collect(ch chan<- prometheus.Metric) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		digitalOceanRateLimit,
+		prometheus.GaugeValue,
+		float64(l.rate.Limit),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		digitalOceanRateLimitRemaining,
+		prometheus.GaugeValue,
+		float64(l.rate.Remaining),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		digitalOceanAPIRetries,
+		prometheus.CounterValue,
+		float64(l.totalRetries),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		digitalOceanThrottles,
+		prometheus.CounterValue,
+		float64(l.totalThrottles),
+	)
+}
+
+func (l *digitalOceanRateLimiter) update(resp *godo.Response) {
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rate = resp.Rate
+}
+
+func (l *digitalOceanRateLimiter) throttleDelay() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate.Limit == 0 || l.rate.Remaining > l.reserve {
+		return 0
+	}
+
+	wait := rest.CapDelay(l.rate.Reset.Time.Sub(time.Now()))
+	if wait <= 0 {
+		return 0
+	}
+
+	l.totalThrottles++
+	logrus.Warnf("DigitalOcean API budget is low (%d/%d remaining); waiting %s for the rate limit reset", l.rate.Remaining, l.rate.Limit, wait)
+
+	return wait
+}
+
+func (l *digitalOceanRateLimiter) retryDelay(resp *godo.Response, attempt int) time.Duration {
+	if delay, ok := rest.RetryAfter(resp.Header); ok {
+		return delay
+	}
+
+	return rest.Backoff(l.baseDelay, attempt)
+}
+
+func (l *digitalOceanRateLimiter) shouldRetry(resp *godo.Response, err error, repeatable bool) bool {
+	if err == nil || resp == nil {
+		return false
+	}
+
+	return rest.Retryable(resp.StatusCode, repeatable)
+}
+
+// Do executes the API call, waiting for the rate limit reset first if the
+// budget is low and retrying on 429 and 5xx responses. It's meant for calls
+// that are safe to repeat, as a failed request may have been processed
+func (l *digitalOceanRateLimiter) Do(ctx context.Context, call func(ctx context.Context) (*godo.Response, error)) (*godo.Response, error) {
+	return l.do(ctx, call, true)
+}
+
+// DoOnce is like Do, but retries only the throttled requests. It's meant for
+// actions that mustn't be issued twice
+func (l *digitalOceanRateLimiter) DoOnce(ctx context.Context, call func(ctx context.Context) (*godo.Response, error)) (*godo.Response, error) {
+	return l.do(ctx, call, false)
+}
+
+func (l *digitalOceanRateLimiter) do(ctx context.Context, call func(ctx context.Context) (*godo.Response, error), repeatable bool) (*godo.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := rest.Wait(ctx, l.throttleDelay()); err != nil {
+			return nil, err
+		}
+
+		resp, err := call(ctx)
+		l.update(resp)
+
+		if !l.shouldRetry(resp, err, repeatable) || attempt >= l.maxRetries {
+			return resp, err
+		}
+
+		delay := l.retryDelay(resp, attempt)
+		logrus.Warnf("DigitalOcean API request failed with status %d; retrying in %s (%d/%d)", resp.StatusCode, delay, attempt+1, l.maxRetries)
+
+		l.lock.Lock()
+		l.totalRetries++
+		l.lock.Unlock()
+
+		if waitErr := rest.Wait(ctx, delay); waitErr != nil {
+			return resp, err
+		}
+	}
+}
+
+func newDigitalOceanRateLimiter(reserve int, maxRetries int) *digitalOceanRateLimiter {
+	return &digitalOceanRateLimiter{
+		reserve:    reserve,
+		maxRetries: maxRetries,
+		baseDelay:  digitalOceanRetryBaseDelay,
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const DefaultDigitalOceanSnapshotTimeout = 30 * time.Minute
+
+func (c *DigitalOceanClient) findDropletSnapshot(ctx context.Context, dropletID int, name string) (string, error) {
+	pageOpts := &godo.ListOptions{
+		Page:    1,
+		PerPage: 200,
+	}
+
+	for {
+		var images []godo.Image
+		resp, err := c.limiter.Do(ctx, func(ctx context.Context) (resp *godo.Response, err error) {
+			images, resp, err = c.client.Droplets.Snapshots(ctx, dropletID, pageOpts)
+			return
+		})
+		if err != nil {
+			return "", err
+		}
+
+		for _, image := range images {
+			if image.Name == name {
+				return strconv.Itoa(image.ID), nil
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return "", fmt.Errorf("snapshot '%s' of droplet %d not found", name, dropletID)
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return "", err
+		}
+		pageOpts.Page = page + 1
+	}
+}
+
+// SnapshotInstance takes a snapshot of the droplet, waits for the snapshot
+// action to complete and returns the ID of the created snapshot
+func (c *DigitalOceanClient) SnapshotInstance(instance Instance, name string) (string, error) {
+	id, err := c.dropletID(instance)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.snapshotTimeout)
+	defer cancelFn()
+
+	var action *godo.Action
+	// repeated action would take another snapshot
+	_, err = c.limiter.DoOnce(ctx, func(ctx context.Context) (resp *godo.Response, err error) {
+		action, resp, err = c.client.DropletActions.Snapshot(ctx, id, name)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = c.waitForAction(ctx, instance, id, action)
+	if err != nil {
+		return "", err
+	}
+
+	return c.findDropletSnapshot(ctx, id, name)
+}
+
+func (c *DigitalOceanClient) ListSnapshots(namePrefix string) (snapshots []Snapshot, err error) {
+	pageOpts := &godo.ListOptions{
+		Page:    1,
+		PerPage: 200,
+	}
+
+	for {
+		var snapshotsList []godo.Snapshot
+		resp, err := c.limiter.Do(context.Background(), func(ctx context.Context) (resp *godo.Response, err error) {
+			snapshotsList, resp, err = c.client.Snapshots.ListDroplet(ctx, pageOpts)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapshot := range snapshotsList {
+			if !strings.HasPrefix(snapshot.Name, namePrefix) {
+				continue
+			}
+
+			createdAt, err := time.Parse(time.RFC3339, snapshot.Created)
+			if err != nil {
+				return nil, fmt.Errorf("invalid creation time of snapshot '%s': %v", snapshot.Name, err)
+			}
+
+			snapshots = append(snapshots, Snapshot{
+				ID:        snapshot.ID,
+				Name:      snapshot.Name,
+				CreatedAt: createdAt,
+			})
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return snapshots, nil
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		pageOpts.Page = page + 1
+	}
+}
+
+func (c *DigitalOceanClient) DeleteSnapshot(snapshot Snapshot) error {
+	_, err := c.limiter.Do(context.Background(), func(ctx context.Context) (*godo.Response, error) {
+		return c.client.Snapshots.Delete(ctx, snapshot.ID)
+	})
+
+	return err
+}
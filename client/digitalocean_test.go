@@ -0,0 +1,345 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type doFakeServer struct {
+	*httptest.Server
+
+	actionStatuses []string
+	polls          int
+	actionErrors   []int
+	actionRequests int
+
+	deleteStatuses []int
+	deletes        int
+	rateRemaining  int
+	rateReset      time.Time
+	retryAfter     string
+
+	taggedDroplets map[string]string
+	listedTags     []string
+
+	taggedResources   []string
+	untaggedResources []string
+	removedKeys       []string
+}
+
+func (s *doFakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.rateReset.IsZero() {
+		w.Header().Set("RateLimit-Limit", "5000")
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d", s.rateRemaining))
+		w.Header().Set("RateLimit-Reset", fmt.Sprintf("%d", s.rateReset.Unix()))
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/droplets/1/actions":
+		s.actionRequests++
+		if s.actionRequests <= len(s.actionErrors) {
+			w.WriteHeader(s.actionErrors[s.actionRequests-1])
+			fmt.Fprint(w, `{"id": "error", "message": "failure"}`)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"action": {"id": 10, "type": "power_off", "status": "in-progress"}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets/1/actions/10":
+		status := s.actionStatuses[len(s.actionStatuses)-1]
+		if s.polls < len(s.actionStatuses) {
+			status = s.actionStatuses[s.polls]
+		}
+		s.polls++
+
+		fmt.Fprintf(w, `{"action": {"id": 10, "type": "power_off", "status": "%s"}}`, status)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets/1":
+		fmt.Fprint(w, `{"droplet": {"id": 1, "name": "runner-abc123-test-1"}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets/3":
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"id": "forbidden", "message": "forbidden"}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets":
+		s.listedTags = append(s.listedTags, r.URL.Query().Get("tag_name"))
+		fmt.Fprint(w, s.taggedDroplets[r.URL.Query().Get("tag_name")])
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets/1/snapshots":
+		fmt.Fprint(w, `{"snapshots": [{"id": 99, "name": "other"}, {"id": 100, "name": "hdc-snapshot-runner-1"}], "meta": {"total": 2}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/snapshots":
+		fmt.Fprint(w, `{"snapshots": [
+			{"id": "100", "name": "hdc-snapshot-runner-1", "created_at": "2018-01-01T10:00:00Z"},
+			{"id": "101", "name": "other", "created_at": "2018-01-01T10:00:00Z"}
+		], "meta": {"total": 2}}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/tags":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"tag": {"name": "hdc-quarantined:10"}}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/tags/hdc-quarantined:10/resources":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.taggedResources = append(s.taggedResources, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Path == "/v2/tags/hdc-quarantined:10/resources":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.untaggedResources = append(s.untaggedResources, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Path == "/v2/account/keys/20":
+		s.removedKeys = append(s.removedKeys, "20")
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Path == "/v2/droplets/1":
+		status := http.StatusNoContent
+		if s.deletes < len(s.deleteStatuses) {
+			status = s.deleteStatuses[s.deletes]
+		}
+		s.deletes++
+
+		if status == http.StatusTooManyRequests && s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			fmt.Fprint(w, `{"id": "error", "message": "failure"}`)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newDOFakeServer(t *testing.T, actionStatuses ...string) (*doFakeServer, *DigitalOceanClient) {
+	fake := &doFakeServer{actionStatuses: actionStatuses}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+
+	client := NewDigitalOceanClient(DigitalOceanConfig{Token: "token", ActionTimeout: 100 * time.Millisecond})
+	client.actionPollInterval = time.Millisecond
+	client.limiter.baseDelay = time.Millisecond
+
+	baseURL, err := url.Parse(fake.URL + "/")
+	require.NoError(t, err)
+	client.client.BaseURL = baseURL
+
+	return fake, client
+}
+
+func TestDigitalOceanStopInstanceWaitsForAction(t *testing.T) {
+	fake, client := newDOFakeServer(t, "in-progress", "in-progress", "completed")
+	defer fake.Close()
+
+	assert.NoError(t, client.StopInstance(Instance{ID: "1"}))
+	assert.Equal(t, 3, fake.polls, "Should poll until the action is completed")
+}
+
+func TestDigitalOceanStopInstanceActionErrored(t *testing.T) {
+	fake, client := newDOFakeServer(t, "errored")
+	defer fake.Close()
+
+	err := client.StopInstance(Instance{ID: "1"})
+	require.Error(t, err)
+	assert.False(t, IsActionTimeout(err))
+	assert.Equal(t, "errored", err.(*ActionError).Status)
+}
+
+func TestDigitalOceanStopInstanceActionTimeout(t *testing.T) {
+	fake, client := newDOFakeServer(t, "in-progress")
+	defer fake.Close()
+
+	err := client.StopInstance(Instance{ID: "1"})
+	assert.True(t, IsActionTimeout(err), "Should report timeout, got: %v", err)
+}
+
+func TestDigitalOceanRetriesThrottledAndFailedRequests(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	client.limiter.maxRetries = 2
+	fake.deleteStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway}
+
+	assert.NoError(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, 3, fake.deletes)
+	assert.Equal(t, int64(2), client.limiter.totalRetries)
+}
+
+func TestDigitalOceanGivesUpAfterMaxRetries(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	client.limiter.maxRetries = 1
+	fake.deleteStatuses = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+
+	assert.Error(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, 2, fake.deletes)
+}
+
+func TestDigitalOceanHonoursRetryAfter(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	// without Retry-After the retry would wait for a minute
+	client.limiter.baseDelay = time.Hour
+	client.limiter.maxRetries = 1
+	fake.retryAfter = "0"
+	fake.deleteStatuses = []int{http.StatusTooManyRequests}
+
+	assert.NoError(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, 2, fake.deletes)
+}
+
+func TestDigitalOceanDeleteInstanceRetriedAfterDeletion(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	client.limiter.maxRetries = 1
+	fake.deleteStatuses = []int{http.StatusBadGateway, http.StatusNotFound}
+
+	assert.NoError(t, client.DeleteInstance(Instance{ID: "1"}), "Droplet deleted by the failed request should be reported as deleted")
+	assert.Equal(t, 2, fake.deletes)
+}
+
+func TestDigitalOceanDoesNotRetryFailedActions(t *testing.T) {
+	fake, client := newDOFakeServer(t, "completed")
+	defer fake.Close()
+
+	client.limiter.maxRetries = 1
+	fake.actionErrors = []int{http.StatusInternalServerError}
+	assert.Error(t, client.StopInstance(Instance{ID: "1"}))
+	assert.Equal(t, 1, fake.actionRequests, "Action that may have been created shouldn't be requested again")
+
+	fake.actionRequests = 0
+	fake.actionErrors = []int{http.StatusTooManyRequests}
+	assert.NoError(t, client.StopInstance(Instance{ID: "1"}))
+	assert.Equal(t, 2, fake.actionRequests, "Throttled action should be retried")
+}
+
+func TestDigitalOceanDoesNotRetryClientErrors(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	fake.deleteStatuses = []int{http.StatusUnprocessableEntity}
+
+	assert.Error(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, 1, fake.deletes)
+}
+
+func TestDigitalOceanWaitsForRateLimitResetWhenBudgetIsLow(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	client.limiter.reserve = 100
+	fake.rateRemaining = 50
+	fake.rateReset = time.Now().Add(2 * time.Second)
+
+	require.NoError(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, int64(0), client.limiter.totalThrottles, "Budget is unknown before the first response")
+
+	started := time.Now()
+	require.NoError(t, client.DeleteInstance(Instance{ID: "1"}))
+	assert.Equal(t, int64(1), client.limiter.totalThrottles)
+	assert.True(t, time.Since(started) > 10*time.Millisecond, "Should wait for the rate limit reset")
+}
+
+func TestDigitalOceanRateLimitWaitIsCapped(t *testing.T) {
+	limiter := newDigitalOceanRateLimiter(100, 1)
+	limiter.rate = godo.Rate{Limit: 5000, Remaining: 50, Reset: godo.Timestamp{Time: time.Now().Add(time.Hour)}}
+
+	assert.Equal(t, time.Minute, limiter.throttleDelay())
+}
+
+func TestDigitalOceanInstanceExists(t *testing.T) {
+	fake, client := newDOFakeServer(t, "completed")
+	defer fake.Close()
+
+	testInstanceExists(t, client, "1", "2", "3")
+}
+
+func TestDigitalOceanListInstancesByTags(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	created := time.Now().Add(-1 * time.Hour).Format(time.RFC3339)
+	fake.taggedDroplets = map[string]string{
+		"runners": fmt.Sprintf(`{"droplets": [
+			{"id": 1, "name": "runner-abc123-1", "created_at": "%[1]s", "tags": ["runners"]},
+			{"id": 2, "name": "other-1", "created_at": "%[1]s", "tags": ["runners"]}
+		], "meta": {"total": 2}}`, created),
+		"ci": fmt.Sprintf(`{"droplets": [
+			{"id": 1, "name": "runner-abc123-1", "created_at": "%[1]s", "tags": ["runners", "ci"]},
+			{"id": 3, "name": "runner-abc123-3", "created_at": "%[1]s", "tags": ["ci"]}
+		], "meta": {"total": 2}}`, created),
+	}
+	client.tags = []string{"runners", "ci"}
+
+	instances, err := client.ListInstances(regexp.MustCompile("^runner-abc123"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"runners", "ci"}, fake.listedTags)
+	require.Len(t, instances, 2, "Should filter by prefix and deduplicate droplets having several tags")
+	assert.Equal(t, "1", instances[0].ID)
+	assert.Equal(t, "3", instances[1].ID)
+}
+
+func TestDigitalOceanTagInstance(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	require.NoError(t, client.TagInstance(Instance{ID: "1"}, "hdc-quarantined:10"))
+	require.Len(t, fake.taggedResources, 1)
+	assert.JSONEq(t, `{"resources": [{"resource_id": "1", "resource_type": "droplet"}]}`, fake.taggedResources[0])
+}
+
+func TestDigitalOceanUntagInstance(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	require.NoError(t, client.UntagInstance(Instance{ID: "1"}, "hdc-quarantined:10"))
+	require.Len(t, fake.untaggedResources, 1)
+	assert.JSONEq(t, `{"resources": [{"resource_id": "1", "resource_type": "droplet"}]}`, fake.untaggedResources[0])
+}
+
+func TestDigitalOceanSnapshotInstance(t *testing.T) {
+	fake, client := newDOFakeServer(t, "in-progress", "completed")
+	defer fake.Close()
+
+	id, err := client.SnapshotInstance(Instance{ID: "1"}, "hdc-snapshot-runner-1")
+	require.NoError(t, err)
+	assert.Equal(t, "100", id)
+	assert.Equal(t, 2, fake.polls, "Should wait for the snapshot action")
+}
+
+func TestDigitalOceanSnapshotInstanceIsNotRetried(t *testing.T) {
+	fake, client := newDOFakeServer(t, "completed")
+	defer fake.Close()
+
+	client.limiter.maxRetries = 1
+	fake.actionErrors = []int{http.StatusBadGateway}
+
+	_, err := client.SnapshotInstance(Instance{ID: "1"}, "hdc-snapshot-runner-1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, fake.actionRequests, "Snapshot that may have been started shouldn't be requested again")
+}
+
+func TestDigitalOceanListSnapshots(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	snapshots, err := client.ListSnapshots("hdc-snapshot-")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "100", snapshots[0].ID)
+	assert.Equal(t, time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC), snapshots[0].CreatedAt)
+}
+
+func TestDigitalOceanRemoveSSHKey(t *testing.T) {
+	fake, client := newDOFakeServer(t)
+	defer fake.Close()
+
+	require.NoError(t, client.RemoveSSHKey("20"))
+	assert.Equal(t, []string{"20"}, fake.removedKeys)
+
+	assert.NoError(t, client.RemoveSSHKey("21"), "Should ignore keys that don't exist")
+	assert.Error(t, client.RemoveSSHKey("invalid"))
+}
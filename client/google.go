@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const (
+	GoogleProviderName = "google"
+
+	googleOperationPollInterval = 2 * time.Second
+	googleOperationTimeout      = 5 * time.Minute
+)
+
+type GoogleConfig struct {
+	Project         string
+	Zones           []string
+	CredentialsFile string
+	Endpoint        string
+}
+
+type GoogleClient struct {
+	service *compute.Service
+	project string
+	zones   []string
+
+	operationPollInterval time.Duration
+	operationTimeout      time.Duration
+}
+
+func (c *GoogleClient) Name() string {
+	return GoogleProviderName
+}
+
+// GoogleInstanceID builds the identifier used for Google Compute Engine
+// instances. Instance names are unique only within a zone, and zonal API
+// calls need both values, so they are both encoded in the ID
+func GoogleInstanceID(zone, name string) string {
+	return fmt.Sprintf("%s/%s", zone, name)
+}
+
+func (c *GoogleClient) parseInstanceID(instance Instance) (zone string, name string, err error) {
+	parts := strings.SplitN(instance.ID, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid Google Compute Engine instance ID %q", instance.ID)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func (c *GoogleClient) computeInstanceToInstance(zone string, computeInstance *compute.Instance) Instance {
+	instance := Instance{
+		ID:       GoogleInstanceID(zone, computeInstance.Name),
+		Name:     computeInstance.Name,
+		Region:   zone,
+		Size:     path.Base(computeInstance.MachineType),
+		Status:   computeInstance.Status,
+		Provider: GoogleProviderName,
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, computeInstance.CreationTimestamp); err == nil {
+		instance.CreatedAt = createdAt
+	}
+
+	if computeInstance.Tags != nil {
+		instance.Tags = append(instance.Tags, computeInstance.Tags.Items...)
+	}
+
+	instance.Tags = append(instance.Tags, labelsToTags(computeInstance.Labels)...)
+
+	return instance
+}
+
+// googleNameFilter narrows the listing to instances with names starting
+// with the prefixes; names are still matched against the regexp afterwards.
+// The value of `eq` is a RE2 regexp that must match the whole name
+func googleNameFilter(instancesPrefixRegexp *regexp.Regexp) string {
+	prefixes := namePrefixes(instancesPrefixRegexp)
+	if len(prefixes) < 1 {
+		return ""
+	}
+
+	var quoted []string
+	for _, prefix := range prefixes {
+		quoted = append(quoted, regexp.QuoteMeta(prefix))
+	}
+
+	return fmt.Sprintf(`name eq "(?:%s).*"`, strings.Join(quoted, "|"))
+}
+
+func (c *GoogleClient) scannedZone(zone string) bool {
+	if len(c.zones) < 1 {
+		return true
+	}
+
+	for _, scanned := range c.zones {
+		if zone == scanned {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *GoogleClient) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	call := c.service.Instances.AggregatedList(c.project)
+	if filter := googleNameFilter(instancesPrefixRegexp); filter != "" {
+		call = call.Filter(filter)
+	}
+
+	err = call.Pages(context.Background(), func(page *compute.InstanceAggregatedList) error {
+		var instancesList []Instance
+		for scope, scopedList := range page.Items {
+			zone := strings.TrimPrefix(scope, "zones/")
+			if !c.scannedZone(zone) {
+				continue
+			}
+
+			// instances of an unreachable zone are missing from the
+			// response, so the listing is incomplete
+			if scopedList.Warning != nil && scopedList.Warning.Code == "UNREACHABLE" {
+				return fmt.Errorf("listing instances in zone %s: %s", zone, scopedList.Warning.Message)
+			}
+
+			for _, computeInstance := range scopedList.Instances {
+				instancesList = append(instancesList, c.computeInstanceToInstance(zone, computeInstance))
+			}
+		}
+
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		return nil
+	})
+
+	return
+}
+
+func (c *GoogleClient) waitForOperation(ctx context.Context, zone string, operation *compute.Operation) (err error) {
+	for {
+		if operation.Status == "DONE" {
+			if operation.Error != nil && len(operation.Error.Errors) > 0 {
+				return fmt.Errorf("operation %s failed: %s", operation.Name, operation.Error.Errors[0].Message)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return &ActionError{
+				Action:     operation.OperationType,
+				InstanceID: GoogleInstanceID(zone, path.Base(operation.TargetLink)),
+				Status:     operation.Status,
+				Timeout:    true,
+			}
+		case <-time.After(c.operationPollInterval):
+		}
+
+		operation, err = c.service.ZoneOperations.Get(c.project, zone, operation.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *GoogleClient) StopInstance(instance Instance) error {
+	zone, name, err := c.parseInstanceID(instance)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.operationTimeout)
+	defer cancelFn()
+
+	operation, err := c.service.Instances.Stop(c.project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	return c.waitForOperation(ctx, zone, operation)
+}
+
+func (c *GoogleClient) DeleteInstance(instance Instance) error {
+	zone, name, err := c.parseInstanceID(instance)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), c.operationTimeout)
+	defer cancelFn()
+
+	operation, err := c.service.Instances.Delete(c.project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	return c.waitForOperation(ctx, zone, operation)
+}
+
+func (c *GoogleClient) InstanceExists(instance Instance) (bool, error) {
+	zone, name, err := c.parseInstanceID(instance)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = c.service.Instances.Get(c.project, zone, name).Context(context.TODO()).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func newGoogleHTTPClient(config GoogleConfig) (*http.Client, error) {
+	ctx := context.Background()
+
+	if config.CredentialsFile == "" {
+		return google.DefaultClient(ctx, compute.ComputeScope)
+	}
+
+	data, err := ioutil.ReadFile(config.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := google.JWTConfigFromJSON(data, compute.ComputeScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.Client(ctx), nil
+}
+
+func newGoogleClient(config GoogleConfig, httpClient *http.Client) (*GoogleClient, error) {
+	if config.Project == "" {
+		return nil, fmt.Errorf("Google Compute Engine project must be set")
+	}
+
+	service, err := compute.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	service.UserAgent = version.AppVersion.UserAgent()
+	if config.Endpoint != "" {
+		service.BasePath = strings.TrimSuffix(config.Endpoint, "/") + "/"
+	}
+
+	return &GoogleClient{
+		service:               service,
+		project:               config.Project,
+		zones:                 config.Zones,
+		operationPollInterval: googleOperationPollInterval,
+		operationTimeout:      googleOperationTimeout,
+	}, nil
+}
+
+func NewGoogleClient(config GoogleConfig) (*GoogleClient, error) {
+	httpClient, err := newGoogleHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGoogleClient(config, httpClient)
+}
@@ -0,0 +1,290 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gceFakeInstance struct {
+	Name              string `json:"name"`
+	CreationTimestamp string `json:"creationTimestamp"`
+	MachineType       string `json:"machineType"`
+	Status            string `json:"status"`
+}
+
+type gceFakeOperation struct {
+	Name   string      `json:"name"`
+	Status string      `json:"status"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+// gceFakeServer emulates the subset of the Compute Engine v1 API used by
+// GoogleClient. Every zonal operation needs one poll before it's DONE
+type gceFakeServer struct {
+	*httptest.Server
+
+	lock       sync.Mutex
+	instances  map[string][]gceFakeInstance
+	operations map[string]int
+	failedOp   string
+	stopped    []string
+	deleted    []string
+
+	filters         []string
+	unreachableZone string
+}
+
+func (s *gceFakeServer) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// aggregatedListInstances responds with one zone per page, applying the
+// `name eq` filter the way the API does
+func (s *gceFakeServer) aggregatedListInstances(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+	s.filters = append(s.filters, filter)
+
+	nameRegexp := regexp.MustCompile("")
+	if filter != "" {
+		value := strings.TrimSuffix(strings.TrimPrefix(filter, `name eq "`), `"`)
+		nameRegexp = regexp.MustCompile("^(?:" + value + ")$")
+	}
+
+	var zones []string
+	for zone := range s.instances {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	page := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		page = len(token)
+	}
+
+	response := map[string]interface{}{}
+	if page < len(zones) {
+		var instances []gceFakeInstance
+		for _, instance := range s.instances[zones[page]] {
+			if nameRegexp.MatchString(instance.Name) {
+				instances = append(instances, instance)
+			}
+		}
+
+		scopedList := map[string]interface{}{"instances": instances}
+		if zones[page] == s.unreachableZone {
+			scopedList = map[string]interface{}{"warning": map[string]string{"code": "UNREACHABLE", "message": "zone is unreachable"}}
+		}
+
+		response["items"] = map[string]interface{}{"zones/" + zones[page]: scopedList}
+	}
+	if page+1 < len(zones) {
+		response["nextPageToken"] = strings.Repeat("x", page+1)
+	}
+
+	s.writeJSON(w, response)
+}
+
+// getInstance responds with 403 for the "forbidden" instance
+func (s *gceFakeServer) getInstance(w http.ResponseWriter, zone string, name string) {
+	if name == "forbidden" {
+		w.WriteHeader(http.StatusForbidden)
+		s.writeJSON(w, map[string]interface{}{"error": map[string]interface{}{"code": http.StatusForbidden, "message": "forbidden"}})
+		return
+	}
+
+	for _, instance := range s.instances[zone] {
+		if instance.Name == name {
+			s.writeJSON(w, instance)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	s.writeJSON(w, map[string]interface{}{"error": map[string]interface{}{"code": http.StatusNotFound, "message": "not found"}})
+}
+
+func (s *gceFakeServer) startOperation(w http.ResponseWriter, name string) {
+	s.operations[name] = 0
+	s.writeJSON(w, gceFakeOperation{Name: name, Status: "RUNNING"})
+}
+
+func (s *gceFakeServer) getOperation(w http.ResponseWriter, name string) {
+	s.operations[name]++
+
+	operation := gceFakeOperation{Name: name, Status: "DONE"}
+	if name == s.failedOp {
+		operation.Error = map[string]interface{}{
+			"errors": []map[string]string{{"code": "RESOURCE_NOT_READY", "message": "not ready"}},
+		}
+	}
+
+	s.writeJSON(w, operation)
+}
+
+func (s *gceFakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/compute/v1/projects/test-project/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "aggregated" && parts[1] == "instances":
+		s.aggregatedListInstances(w, r)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "instances":
+		s.getInstance(w, parts[1], parts[3])
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[2] == "instances":
+		s.deleted = append(s.deleted, parts[1]+"/"+parts[3])
+		s.startOperation(w, "delete-"+parts[3])
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[4] == "stop":
+		s.stopped = append(s.stopped, parts[1]+"/"+parts[3])
+		s.startOperation(w, "stop-"+parts[3])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "operations":
+		s.getOperation(w, parts[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newGCEFakeServer(t *testing.T, instances map[string][]gceFakeInstance) (*gceFakeServer, *GoogleClient) {
+	fake := &gceFakeServer{
+		instances:  instances,
+		operations: make(map[string]int),
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+
+	client, err := newGoogleClient(GoogleConfig{
+		Project:  "test-project",
+		Endpoint: fake.URL + "/compute/v1/",
+	}, http.DefaultClient)
+	require.NoError(t, err)
+
+	client.operationPollInterval = time.Millisecond
+
+	return fake, client
+}
+
+func TestGoogleListInstancesAcrossZones(t *testing.T) {
+	old := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	fake, client := newGCEFakeServer(t, map[string][]gceFakeInstance{
+		"us-east1-b": {
+			{Name: "runner-abc123-test-1", CreationTimestamp: old, MachineType: "zones/us-east1-b/machineTypes/n1-standard-1", Status: "RUNNING"},
+			{Name: "runner-abc123-test-2", CreationTimestamp: old, MachineType: "zones/us-east1-b/machineTypes/n1-standard-1", Status: "RUNNING"},
+			{Name: "other-machine", CreationTimestamp: old, Status: "RUNNING"},
+		},
+		"europe-west1-c": {
+			{Name: "runner-abc123-test-3", CreationTimestamp: old, MachineType: "zones/europe-west1-c/machineTypes/n1-standard-2", Status: "TERMINATED"},
+			{Name: "runner-abc123-test-4", CreationTimestamp: time.Now().Format(time.RFC3339), Status: "RUNNING"},
+		},
+	})
+	defer fake.Close()
+
+	instances, err := client.ListInstances(regexp.MustCompile("^runner-abc123"), 10*time.Minute)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+		assert.Equal(t, GoogleProviderName, instance.Provider)
+	}
+	assert.ElementsMatch(t, []string{
+		"us-east1-b/runner-abc123-test-1",
+		"us-east1-b/runner-abc123-test-2",
+		"europe-west1-c/runner-abc123-test-3",
+	}, ids)
+	assert.Equal(t, []string{`name eq "(?:runner-abc123).*"`}, fake.filters[:1], "Should filter instances by name on the server side")
+}
+
+func TestGoogleListInstancesInConfiguredZones(t *testing.T) {
+	old := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	fake, client := newGCEFakeServer(t, map[string][]gceFakeInstance{
+		"us-east1-b": {
+			{Name: "runner-abc123-test-1", CreationTimestamp: old, Status: "RUNNING"},
+		},
+		"europe-west1-c": {
+			{Name: "runner-abc123-test-2", CreationTimestamp: old, Status: "RUNNING"},
+		},
+	})
+	defer fake.Close()
+
+	client.zones = []string{"europe-west1-c"}
+
+	instances, err := client.ListInstances(regexp.MustCompile("^runner-abc123"), 10*time.Minute)
+	require.NoError(t, err)
+	if assert.Len(t, instances, 1) {
+		assert.Equal(t, "europe-west1-c/runner-abc123-test-2", instances[0].ID)
+	}
+}
+
+func TestGoogleListInstancesFailsForUnreachableZone(t *testing.T) {
+	fake, client := newGCEFakeServer(t, map[string][]gceFakeInstance{
+		"us-east1-b": {},
+	})
+	defer fake.Close()
+
+	fake.unreachableZone = "us-east1-b"
+
+	_, err := client.ListInstances(regexp.MustCompile("^runner-abc123"), 10*time.Minute)
+	assert.Error(t, err)
+}
+
+func TestGoogleNameFilter(t *testing.T) {
+	assert.Equal(t, `name eq "(?:runner-abc123|other\.x).*"`, googleNameFilter(regexp.MustCompile(`^(runner-abc123|other\.x)`)))
+	assert.Empty(t, googleNameFilter(regexp.MustCompile("runner")), "Should not filter by unanchored regexp")
+}
+
+func TestGoogleStopAndDeleteInstanceWaitForOperations(t *testing.T) {
+	fake, client := newGCEFakeServer(t, nil)
+	defer fake.Close()
+
+	instance := Instance{ID: GoogleInstanceID("us-east1-b", "runner-abc123-test-1")}
+
+	assert.NoError(t, client.StopInstance(instance))
+	assert.NoError(t, client.DeleteInstance(instance))
+	assert.Equal(t, []string{"us-east1-b/runner-abc123-test-1"}, fake.stopped)
+	assert.Equal(t, []string{"us-east1-b/runner-abc123-test-1"}, fake.deleted)
+	assert.Equal(t, 1, fake.operations["stop-runner-abc123-test-1"], "Stop operation should be polled")
+	assert.Equal(t, 1, fake.operations["delete-runner-abc123-test-1"], "Delete operation should be polled")
+}
+
+func TestGoogleDeleteInstanceFailedOperation(t *testing.T) {
+	fake, client := newGCEFakeServer(t, nil)
+	defer fake.Close()
+	fake.failedOp = "delete-runner-abc123-test-1"
+
+	err := client.DeleteInstance(Instance{ID: GoogleInstanceID("us-east1-b", "runner-abc123-test-1")})
+	assert.Error(t, err)
+}
+
+func TestGoogleInvalidInstanceID(t *testing.T) {
+	fake, client := newGCEFakeServer(t, nil)
+	defer fake.Close()
+
+	assert.Error(t, client.DeleteInstance(Instance{ID: "runner-abc123-test-1"}))
+}
+
+func TestGoogleInstanceExists(t *testing.T) {
+	fake, client := newGCEFakeServer(t, map[string][]gceFakeInstance{
+		"us-east1-b": {
+			{Name: "runner-abc123-test-1", CreationTimestamp: time.Now().Format(time.RFC3339), Status: "RUNNING"},
+		},
+	})
+	defer fake.Close()
+
+	testInstanceExists(t, client,
+		GoogleInstanceID("us-east1-b", "runner-abc123-test-1"),
+		GoogleInstanceID("us-east1-b", "runner-abc123-test-2"),
+		GoogleInstanceID("us-east1-b", "forbidden"))
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client/internal/rest"
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const (
+	HetznerProviderName = "hetzner"
+
+	hetznerDefaultEndpoint = "https://api.hetzner.cloud/v1"
+	hetznerPageSize        = 50
+)
+
+type hetznerServer struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Created    string            `json:"created"`
+	Labels     map[string]string `json:"labels"`
+	ServerType struct {
+		Name string `json:"name"`
+	} `json:"server_type"`
+	Datacenter struct {
+		Location struct {
+			Name string `json:"name"`
+		} `json:"location"`
+	} `json:"datacenter"`
+}
+
+type hetznerServersPage struct {
+	Servers []hetznerServer `json:"servers"`
+	Meta    struct {
+		Pagination struct {
+			NextPage *int `json:"next_page"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+type HetznerClient struct {
+	client *rest.Client
+}
+
+func (c *HetznerClient) Name() string {
+	return HetznerProviderName
+}
+
+func (c *HetznerClient) serverToInstance(server hetznerServer) Instance {
+	instance := Instance{
+		ID:       strconv.Itoa(server.ID),
+		Name:     server.Name,
+		Region:   server.Datacenter.Location.Name,
+		Size:     server.ServerType.Name,
+		Status:   server.Status,
+		Provider: HetznerProviderName,
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, server.Created); err == nil {
+		instance.CreatedAt = createdAt
+	}
+
+	instance.Tags = labelsToTags(server.Labels)
+
+	return instance
+}
+
+func (c *HetznerClient) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	err = rest.Paginate(context.Background(), "1", func(ctx context.Context, page string) (string, error) {
+		query := url.Values{}
+		query.Set("page", page)
+		query.Set("per_page", strconv.Itoa(hetznerPageSize))
+
+		var serversPage hetznerServersPage
+		_, err := c.client.Do(ctx, http.MethodGet, "/servers", query, nil, &serversPage)
+		if err != nil {
+			return "", err
+		}
+
+		var instancesList []Instance
+		for _, server := range serversPage.Servers {
+			instancesList = append(instancesList, c.serverToInstance(server))
+		}
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		if serversPage.Meta.Pagination.NextPage == nil {
+			return "", nil
+		}
+
+		return strconv.Itoa(*serversPage.Meta.Pagination.NextPage), nil
+	})
+
+	return
+}
+
+func (c *HetznerClient) StopInstance(instance Instance) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancelFn()
+
+	_, err := c.client.Do(ctx, http.MethodPost, fmt.Sprintf("/servers/%s/actions/poweroff", url.PathEscape(instance.ID)), nil, nil, nil)
+	return err
+}
+
+func (c *HetznerClient) DeleteInstance(instance Instance) error {
+	return c.client.Delete(context.Background(), fmt.Sprintf("/servers/%s", url.PathEscape(instance.ID)))
+}
+
+func (c *HetznerClient) InstanceExists(instance Instance) (bool, error) {
+	return c.client.Exists(context.Background(), fmt.Sprintf("/servers/%s", url.PathEscape(instance.ID)))
+}
+
+func NewHetznerClient(apiToken string, endpoint string) *HetznerClient {
+	if endpoint == "" {
+		endpoint = hetznerDefaultEndpoint
+	}
+
+	return &HetznerClient{
+		client: &rest.Client{
+			BaseURL:    endpoint,
+			Token:      apiToken,
+			UserAgent:  version.AppVersion.UserAgent(),
+			MaxRetries: rest.DefaultMaxRetries,
+		},
+	}
+}
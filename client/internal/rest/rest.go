@@ -0,0 +1,383 @@
+// Package rest is a small toolkit for JSON REST APIs of cloud providers that
+// don't have (or don't need) a dedicated SDK
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout    = 1 * time.Minute
+	DefaultMaxRetries = 3
+
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 1 * time.Minute
+
+	// maxPages protects from endless loops when an API keeps returning the
+	// same next page reference
+	maxPages = 1000
+)
+
+var defaultHTTPClient = &http.Client{
+	Timeout: DefaultTimeout,
+}
+
+type Rate struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type Response struct {
+	*http.Response
+
+	Rate Rate
+}
+
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client sends requests to a JSON REST API. When the API reports that the
+// rate limit is exhausted, the next request waits for the reset. Throttled
+// requests (429) are retried up to MaxRetries times, honouring Retry-After;
+// failed ones (5xx) are retried only for idempotent methods, as the request
+// may have been processed before failing
+type Client struct {
+	BaseURL    string
+	Token      string
+	UserAgent  string
+	HTTPClient *http.Client
+	MaxRetries int
+	RetryDelay time.Duration
+
+	lock     sync.Mutex
+	lastRate Rate
+}
+
+func (c *Client) LastRate() Rate {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lastRate
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Request, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	return req, nil
+}
+
+// Do sends the request and decodes JSON response into out (if not nil)
+func (c *Client) Do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := Wait(ctx, c.throttleDelay()); err != nil {
+			return nil, err
+		}
+
+		response, err := c.do(ctx, method, path, query, body, out)
+		if !shouldRetry(method, response, err) || attempt >= c.MaxRetries {
+			return response, err
+		}
+
+		if waitErr := Wait(ctx, c.retryDelay(response, attempt)); waitErr != nil {
+			return response, err
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) (*Response, error) {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+
+	httpResponse, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	response := &Response{
+		Response: httpResponse,
+		Rate:     ParseRate(httpResponse.Header),
+	}
+
+	c.lock.Lock()
+	c.lastRate = response.Rate
+	c.lock.Unlock()
+
+	data, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return response, err
+	}
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
+		return response, &Error{
+			StatusCode: httpResponse.StatusCode,
+			Message:    strings.TrimSpace(string(data)),
+		}
+	}
+
+	if out == nil || len(data) == 0 {
+		return response, nil
+	}
+
+	return response, json.Unmarshal(data, out)
+}
+
+// throttleDelay returns how long to wait before the next request, if the
+// last response reported that no requests are remaining
+func (c *Client) throttleDelay() time.Duration {
+	rate := c.LastRate()
+	if rate.Remaining != 0 || rate.Reset.IsZero() {
+		return 0
+	}
+
+	return CapDelay(rate.Reset.Sub(time.Now()))
+}
+
+func (c *Client) retryDelay(response *Response, attempt int) time.Duration {
+	// Retry-After is the server's explicit request, so it's not capped
+	if delay, ok := RetryAfter(response.Header); ok {
+		return delay
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests && !response.Rate.Reset.IsZero() {
+		if delay := response.Rate.Reset.Sub(time.Now()); delay > 0 {
+			return CapDelay(delay)
+		}
+	}
+
+	baseDelay := c.RetryDelay
+	if baseDelay <= 0 {
+		baseDelay = retryBaseDelay
+	}
+
+	return Backoff(baseDelay, attempt)
+}
+
+// Backoff returns the jittered exponential delay before the given retry
+// attempt, capped with CapDelay
+func Backoff(baseDelay time.Duration, attempt int) time.Duration {
+	delay := CapDelay(baseDelay << uint(attempt))
+	if delay <= 0 {
+		delay = retryMaxDelay
+	}
+
+	// "equal jitter": half of the delay is fixed, the other half is random
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// CapDelay limits computed waits to a minute, so a single request can't
+// block its caller for the whole rate limit window
+func CapDelay(delay time.Duration) time.Duration {
+	if delay < 0 {
+		return 0
+	}
+
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+
+	return delay
+}
+
+// Idempotent returns true for methods that can be safely sent again when
+// it's unknown if the previous request was processed
+func Idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// shouldRetry tells if the failed request should be sent again
+func shouldRetry(method string, response *Response, err error) bool {
+	if err == nil || response == nil {
+		return false
+	}
+
+	return Retryable(response.StatusCode, Idempotent(method))
+}
+
+// Retryable returns true for throttled requests, which were rejected
+// without being processed, and for server errors of requests that are safe
+// to repeat
+func Retryable(statusCode int, repeatable bool) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return statusCode >= http.StatusInternalServerError && repeatable
+}
+
+// Wait sleeps for the delay, returning early with an error when the
+// context is done
+func Wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// RetryAfter reads the Retry-After header, given either in seconds or as
+// an HTTP date
+func RetryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now()), true
+	}
+
+	return 0, false
+}
+
+// Exists checks if the resource exists, treating 404 response as a valid
+// "doesn't exist" answer
+func (c *Client) Exists(ctx context.Context, path string) (bool, error) {
+	_, err := c.Do(ctx, http.MethodGet, path, nil, nil, nil)
+	if apiErr, ok := err.(*Error); ok && apiErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Delete deletes the resource, treating 404 response as success: the
+// request is retried after server errors, and the retry may find the
+// resource already deleted by the failed attempt
+func (c *Client) Delete(ctx context.Context, path string) error {
+	_, err := c.Do(ctx, http.MethodDelete, path, nil, nil, nil)
+	if apiErr, ok := err.(*Error); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func headerInt(header http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(value)
+		if err == nil {
+			return number, true
+		}
+	}
+
+	return 0, false
+}
+
+// ParseRate reads rate limit headers. Both the `RateLimit-*` and the
+// `X-RateLimit-*` flavours are supported; reset is a UNIX timestamp
+func ParseRate(header http.Header) Rate {
+	rate := Rate{Limit: -1, Remaining: -1}
+
+	if limit, ok := headerInt(header, "RateLimit-Limit", "X-RateLimit-Limit"); ok {
+		rate.Limit = limit
+	}
+
+	if remaining, ok := headerInt(header, "RateLimit-Remaining", "X-RateLimit-Remaining"); ok {
+		rate.Remaining = remaining
+	}
+
+	if reset, ok := headerInt(header, "RateLimit-Reset", "X-RateLimit-Reset"); ok {
+		rate.Reset = time.Unix(int64(reset), 0)
+	}
+
+	return rate
+}
+
+// PageFunc fetches the page referenced by page (a number or a cursor,
+// depending on the API) and returns reference to the next one or an empty
+// string if it was the last page
+type PageFunc func(ctx context.Context, page string) (next string, err error)
+
+func Paginate(ctx context.Context, first string, fn PageFunc) error {
+	page := first
+	for i := 0; i < maxPages; i++ {
+		next, err := fn(ctx, page)
+		if err != nil {
+			return err
+		}
+
+		if next == "" || next == page {
+			return nil
+		}
+
+		page = next
+	}
+
+	return fmt.Errorf("pagination exceeded %d pages", maxPages)
+}
@@ -0,0 +1,172 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	header := http.Header{}
+	header.Set("RateLimit-Limit", "3600")
+	header.Set("RateLimit-Remaining", "120")
+	header.Set("RateLimit-Reset", "1500000000")
+
+	assert.Equal(t, Rate{Limit: 3600, Remaining: 120, Reset: time.Unix(1500000000, 0)}, ParseRate(header))
+
+	header = http.Header{}
+	header.Set("X-RateLimit-Limit", "800")
+	header.Set("X-RateLimit-Remaining", "799")
+
+	assert.Equal(t, Rate{Limit: 800, Remaining: 799}, ParseRate(header))
+	assert.Equal(t, Rate{Limit: -1, Remaining: -1}, ParseRate(http.Header{}))
+}
+
+func TestClientDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "test-agent", r.Header.Get("User-Agent"))
+
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		switch r.URL.Path {
+		case "/v1/ok":
+			assert.Equal(t, "2", r.URL.Query().Get("page"))
+			w.Write([]byte(`{"name": "value"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL + "/v1/", Token: "token", UserAgent: "test-agent"}
+
+	var out struct {
+		Name string `json:"name"`
+	}
+	response, err := client.Do(context.Background(), http.MethodGet, "/ok", map[string][]string{"page": {"2"}}, nil, &out)
+	require.NoError(t, err)
+	assert.Equal(t, "value", out.Name)
+	assert.Equal(t, 10, response.Rate.Remaining)
+	assert.Equal(t, 10, client.LastRate().Remaining)
+
+	_, err = client.Do(context.Background(), http.MethodDelete, "/missing", nil, nil, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(*Error).StatusCode)
+}
+
+func TestPaginate(t *testing.T) {
+	var pages []string
+	err := Paginate(context.Background(), "1", func(ctx context.Context, page string) (string, error) {
+		pages = append(pages, page)
+		if page == "3" {
+			return "", nil
+		}
+		return map[string]string{"1": "2", "2": "3"}[page], nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, pages)
+
+	err = Paginate(context.Background(), "1", func(ctx context.Context, page string) (string, error) {
+		return "", errors.New("page error")
+	})
+	assert.EqualError(t, err, "page error")
+
+	err = Paginate(context.Background(), "", func(ctx context.Context, cursor string) (string, error) {
+		return cursor + "x", nil
+	})
+	assert.Error(t, err, "Endless pagination should be stopped")
+}
+
+func TestClientRetries(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		switch r.URL.Path {
+		case "/throttled":
+			if requests[r.Method+" "+r.URL.Path] == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, MaxRetries: 2, RetryDelay: time.Millisecond}
+
+	_, err := client.Do(context.Background(), http.MethodPost, "/throttled", nil, nil, nil)
+	assert.NoError(t, err, "Throttled request wasn't processed, so it should be retried")
+	assert.Equal(t, 2, requests["POST /throttled"])
+
+	_, err = client.Do(context.Background(), http.MethodGet, "/failing", nil, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, requests["GET /failing"], "Idempotent request should be retried MaxRetries times")
+
+	_, err = client.Do(context.Background(), http.MethodPost, "/failing", nil, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests["POST /failing"], "Non-idempotent request may have been processed and shouldn't be retried")
+}
+
+func TestClientDelete(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		requests++
+		switch {
+		case r.URL.Path == "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case requests == 1:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, MaxRetries: 2, RetryDelay: time.Millisecond}
+
+	assert.NoError(t, client.Delete(context.Background(), "/deleted"), "Retry should find the resource deleted by the failed attempt")
+	assert.Equal(t, 2, requests)
+
+	assert.Error(t, client.Delete(context.Background(), "/forbidden"))
+}
+
+func TestClientThrottleDelay(t *testing.T) {
+	client := &Client{}
+	assert.Zero(t, client.throttleDelay())
+
+	client.lastRate = Rate{Limit: 100, Remaining: 1, Reset: time.Now().Add(30 * time.Second)}
+	assert.Zero(t, client.throttleDelay())
+
+	client.lastRate.Remaining = 0
+	assert.InDelta(t, 30*time.Second, client.throttleDelay(), float64(2*time.Second))
+
+	client.lastRate.Reset = time.Now().Add(2 * time.Hour)
+	assert.Equal(t, retryMaxDelay, client.throttleDelay())
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := http.Header{}
+	_, ok := RetryAfter(header)
+	assert.False(t, ok)
+
+	header.Set("Retry-After", "120")
+	delay, ok := RetryAfter(header)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	delay, ok = RetryAfter(header)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, delay, float64(2*time.Second))
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client/internal/rest"
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const (
+	LinodeProviderName = "linode"
+
+	linodeDefaultEndpoint = "https://api.linode.com/v4"
+	linodePageSize        = 500
+
+	// Linode API returns timestamps in UTC, without the zone designator
+	linodeTimeFormat = "2006-01-02T15:04:05"
+)
+
+type linodeInstance struct {
+	ID      int      `json:"id"`
+	Label   string   `json:"label"`
+	Status  string   `json:"status"`
+	Created string   `json:"created"`
+	Region  string   `json:"region"`
+	Type    string   `json:"type"`
+	Tags    []string `json:"tags"`
+}
+
+type linodeInstancesPage struct {
+	Data  []linodeInstance `json:"data"`
+	Page  int              `json:"page"`
+	Pages int              `json:"pages"`
+}
+
+type LinodeClient struct {
+	client *rest.Client
+}
+
+func (c *LinodeClient) Name() string {
+	return LinodeProviderName
+}
+
+func (c *LinodeClient) linodeToInstance(linode linodeInstance) Instance {
+	instance := Instance{
+		ID:       strconv.Itoa(linode.ID),
+		Name:     linode.Label,
+		Region:   linode.Region,
+		Size:     linode.Type,
+		Tags:     linode.Tags,
+		Status:   linode.Status,
+		Provider: LinodeProviderName,
+	}
+
+	if createdAt, err := time.Parse(linodeTimeFormat, linode.Created); err == nil {
+		instance.CreatedAt = createdAt
+	}
+
+	return instance
+}
+
+func (c *LinodeClient) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	err = rest.Paginate(context.Background(), "1", func(ctx context.Context, page string) (string, error) {
+		query := url.Values{}
+		query.Set("page", page)
+		query.Set("page_size", strconv.Itoa(linodePageSize))
+
+		var instancesPage linodeInstancesPage
+		_, err := c.client.Do(ctx, http.MethodGet, "/linode/instances", query, nil, &instancesPage)
+		if err != nil {
+			return "", err
+		}
+
+		var instancesList []Instance
+		for _, linode := range instancesPage.Data {
+			instancesList = append(instancesList, c.linodeToInstance(linode))
+		}
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		if instancesPage.Page >= instancesPage.Pages {
+			return "", nil
+		}
+
+		return strconv.Itoa(instancesPage.Page + 1), nil
+	})
+
+	return
+}
+
+func (c *LinodeClient) StopInstance(instance Instance) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancelFn()
+
+	_, err := c.client.Do(ctx, http.MethodPost, fmt.Sprintf("/linode/instances/%s/shutdown", url.PathEscape(instance.ID)), nil, nil, nil)
+	return err
+}
+
+func (c *LinodeClient) DeleteInstance(instance Instance) error {
+	return c.client.Delete(context.Background(), fmt.Sprintf("/linode/instances/%s", url.PathEscape(instance.ID)))
+}
+
+func (c *LinodeClient) InstanceExists(instance Instance) (bool, error) {
+	return c.client.Exists(context.Background(), fmt.Sprintf("/linode/instances/%s", url.PathEscape(instance.ID)))
+}
+
+func NewLinodeClient(apiToken string, endpoint string) *LinodeClient {
+	if endpoint == "" {
+		endpoint = linodeDefaultEndpoint
+	}
+
+	return &LinodeClient{
+		client: &rest.Client{
+			BaseURL:    endpoint,
+			Token:      apiToken,
+			UserAgent:  version.AppVersion.UserAgent(),
+			MaxRetries: rest.DefaultMaxRetries,
+		},
+	}
+}
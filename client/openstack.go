@@ -0,0 +1,173 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const OpenStackProviderName = "openstack"
+
+type OpenStackConfig struct {
+	AuthURL    string
+	Username   string
+	Password   string
+	DomainName string
+	TenantName string
+	TenantID   string
+	Region     string
+	Interface  string
+}
+
+type OpenStackClient struct {
+	client *gophercloud.ServiceClient
+	region string
+}
+
+func (c *OpenStackClient) Name() string {
+	return OpenStackProviderName
+}
+
+func (c *OpenStackClient) serverToInstance(server servers.Server) Instance {
+	instance := Instance{
+		ID:        server.ID,
+		Name:      server.Name,
+		CreatedAt: server.Created,
+		Region:    c.region,
+		Tags:      labelsToTags(server.Metadata),
+		Status:    server.Status,
+		Provider:  OpenStackProviderName,
+	}
+
+	if flavorID, ok := server.Flavor["id"].(string); ok {
+		instance.Size = flavorID
+	}
+
+	return instance
+}
+
+// openStackNameFilter returns the value of Nova's name filter. Nova matches
+// it as a regular expression of the database, which dialect differs from
+// Go's, so only the common literal prefix of the names is passed, cut at the
+// first character that could be a metacharacter
+func openStackNameFilter(instancesPrefixRegexp *regexp.Regexp) string {
+	prefixes := namePrefixes(instancesPrefixRegexp)
+	if len(prefixes) < 1 {
+		return ""
+	}
+
+	filter := prefixes[0]
+	for _, prefix := range prefixes[1:] {
+		for !strings.HasPrefix(prefix, filter) {
+			filter = filter[:len(filter)-1]
+		}
+	}
+
+	if i := strings.IndexFunc(filter, isNotOpenStackFilterLiteral); i >= 0 {
+		filter = filter[:i]
+	}
+
+	return filter
+}
+
+func isNotOpenStackFilterLiteral(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
+
+// ListInstances uses Nova's name filter to limit the listing on the server
+// side; names are still matched against the regexp afterwards
+func (c *OpenStackClient) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	listOpts := servers.ListOpts{
+		Name: openStackNameFilter(instancesPrefixRegexp),
+	}
+
+	err = servers.List(c.client, listOpts).EachPage(func(page pagination.Page) (bool, error) {
+		serversList, err := servers.ExtractServers(page)
+		if err != nil {
+			return false, err
+		}
+
+		var instancesList []Instance
+		for _, server := range serversList {
+			instancesList = append(instancesList, c.serverToInstance(server))
+		}
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		return true, nil
+	})
+
+	return
+}
+
+func (c *OpenStackClient) StopInstance(instance Instance) error {
+	return startstop.Stop(c.client, instance.ID).ExtractErr()
+}
+
+func (c *OpenStackClient) DeleteInstance(instance Instance) error {
+	return servers.Delete(c.client, instance.ID).ExtractErr()
+}
+
+func (c *OpenStackClient) InstanceExists(instance Instance) (bool, error) {
+	server, err := servers.Get(c.client, instance.ID).Extract()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return server.Status != "DELETED" && server.Status != "SOFT_DELETED", nil
+}
+
+func NewOpenStackClient(config OpenStackConfig) (*OpenStackClient, error) {
+	if config.AuthURL == "" {
+		return nil, fmt.Errorf("OpenStack authentication URL must be set")
+	}
+
+	provider, err := openstack.NewClient(config.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.UserAgent.Prepend(version.AppVersion.UserAgent())
+
+	err = openstack.Authenticate(provider, gophercloud.AuthOptions{
+		IdentityEndpoint: config.AuthURL,
+		Username:         config.Username,
+		Password:         config.Password,
+		DomainName:       config.DomainName,
+		TenantName:       config.TenantName,
+		TenantID:         config.TenantID,
+		AllowReauth:      true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OpenStack authentication failed: %v", err)
+	}
+
+	availability := gophercloud.AvailabilityPublic
+	if config.Interface != "" {
+		availability = gophercloud.Availability(config.Interface)
+	}
+
+	compute, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{
+		Region:       config.Region,
+		Availability: availability,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OpenStackClient{
+		client: compute,
+		region: config.Region,
+	}, nil
+}
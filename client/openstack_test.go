@@ -0,0 +1,241 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openStackFakeServer struct {
+	*httptest.Server
+
+	t        *testing.T
+	servers  [][]map[string]interface{}
+	requests []string
+}
+
+func (s *openStackFakeServer) token(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	if !assert.NoError(s.t, json.NewDecoder(r.Body).Decode(&body)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user := body.Auth.Identity.Password.User
+	if user.Name != "runner" || user.Password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("X-Subject-Token", "fake-token")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": {
+		"expires_at": "%s",
+		"catalog": [{
+			"type": "compute",
+			"name": "nova",
+			"endpoints": [{"id": "1", "interface": "public", "region": "RegionOne", "region_id": "RegionOne", "url": "%s/compute/v2.1/"}]
+		}]
+	}}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), s.URL)
+}
+
+func (s *openStackFakeServer) listServers(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(s.t, "runner-abc123", r.URL.Query().Get("name"), "Should filter by name on the server side")
+	assert.Empty(s.t, r.URL.Query().Get("changes-since"), "Should list servers regardless of their last change")
+
+	page := 0
+	fmt.Sscanf(r.URL.Query().Get("marker"), "page-%d", &page)
+
+	response := map[string]interface{}{
+		"servers": s.servers[page],
+	}
+	if page+1 < len(s.servers) {
+		response["servers_links"] = []map[string]string{
+			{"rel": "next", "href": fmt.Sprintf("%s/compute/v2.1/servers/detail?marker=page-%d&name=%s", s.URL, page+1, r.URL.Query().Get("name"))},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *openStackFakeServer) getServer(w http.ResponseWriter, id string) {
+	for _, page := range s.servers {
+		for _, server := range page {
+			if server["id"] != id {
+				continue
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"server": server})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (s *openStackFakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/identity/v3/auth/tokens" && r.Method == http.MethodPost {
+		s.token(w, r)
+		return
+	}
+
+	assert.Equal(s.t, "fake-token", r.Header.Get("X-Auth-Token"))
+
+	switch {
+	case r.URL.Path == "/compute/v2.1/servers/detail" && r.Method == http.MethodGet:
+		s.listServers(w, r)
+	case r.URL.Path == "/compute/v2.1/servers/uuid-forbidden" && r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusForbidden)
+	case strings.HasPrefix(r.URL.Path, "/compute/v2.1/servers/") && r.Method == http.MethodGet:
+		s.getServer(w, strings.TrimPrefix(r.URL.Path, "/compute/v2.1/servers/"))
+	case strings.HasPrefix(r.URL.Path, "/compute/v2.1/servers/") && r.Method == http.MethodPost:
+		body, _ := ioutil.ReadAll(r.Body)
+		s.requests = append(s.requests, fmt.Sprintf("POST %s %s", r.URL.Path, strings.TrimSpace(string(body))))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(r.URL.Path, "/compute/v2.1/servers/") && r.Method == http.MethodDelete:
+		s.requests = append(s.requests, "DELETE "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func openStackFakeNovaServer(id string, name string, created time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       id,
+		"name":     name,
+		"status":   "ACTIVE",
+		"created":  created.UTC().Format(time.RFC3339),
+		"flavor":   map[string]string{"id": "m1.small"},
+		"metadata": map[string]string{"keep": ""},
+	}
+}
+
+func newOpenStackFakeServer(t *testing.T, servers [][]map[string]interface{}) (*openStackFakeServer, *OpenStackClient) {
+	fake := &openStackFakeServer{t: t, servers: servers}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+
+	client, err := NewOpenStackClient(OpenStackConfig{
+		AuthURL:    fake.URL + "/identity/v3",
+		Username:   "runner",
+		Password:   "secret",
+		DomainName: "Default",
+		TenantName: "runners",
+		Region:     "RegionOne",
+	})
+	require.NoError(t, err)
+
+	return fake, client
+}
+
+func TestOpenStackListInstances(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+
+	fake, client := newOpenStackFakeServer(t, [][]map[string]interface{}{
+		{
+			openStackFakeNovaServer("uuid-1", "runner-abc123-test-1", old),
+			openStackFakeNovaServer("uuid-2", "runner-abc123-test-2", time.Now()),
+		},
+		{
+			openStackFakeNovaServer("uuid-3", "runner-abc123-test-3", old),
+		},
+	})
+	defer fake.Close()
+
+	instances, err := client.ListInstances(regexp.MustCompile("^(runner-abc123)"), 10*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+
+	assert.Equal(t, "uuid-1", instances[0].ID)
+	assert.Equal(t, "uuid-3", instances[1].ID)
+	assert.Equal(t, "RegionOne", instances[0].Region)
+	assert.Equal(t, "m1.small", instances[0].Size)
+	assert.Equal(t, []string{"keep"}, instances[0].Tags)
+	assert.Equal(t, OpenStackProviderName, instances[0].Provider)
+}
+
+func TestOpenStackNameFilter(t *testing.T) {
+	examples := map[string]struct {
+		regexp         string
+		expectedFilter string
+	}{
+		"single prefix":     {regexp: "^runner-abc123", expectedFilter: "runner-abc123"},
+		"common prefix":     {regexp: "^(runner-abc123|runner-def456)", expectedFilter: "runner-"},
+		"metacharacter":     {regexp: `^runner\.abc123`, expectedFilter: "runner"},
+		"no common prefix":  {regexp: "^(runner|other)", expectedFilter: ""},
+		"unanchored regexp": {regexp: "runner-abc123", expectedFilter: ""},
+		"case insensitive":  {regexp: "(?i)^runner", expectedFilter: ""},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, example.expectedFilter, openStackNameFilter(regexp.MustCompile(example.regexp)))
+		})
+	}
+}
+
+func TestOpenStackStopAndDeleteInstance(t *testing.T) {
+	fake, client := newOpenStackFakeServer(t, nil)
+	defer fake.Close()
+
+	instance := Instance{ID: "uuid-1"}
+
+	assert.NoError(t, client.StopInstance(instance))
+	assert.NoError(t, client.DeleteInstance(instance))
+	assert.Equal(t, []string{
+		`POST /compute/v2.1/servers/uuid-1/action {"os-stop":null}`,
+		"DELETE /compute/v2.1/servers/uuid-1",
+	}, fake.requests)
+}
+
+func TestOpenStackAuthenticationFailure(t *testing.T) {
+	fake := &openStackFakeServer{t: t}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	defer fake.Close()
+
+	_, err := NewOpenStackClient(OpenStackConfig{
+		AuthURL:  fake.URL + "/identity/v3",
+		Username: "runner",
+		Password: "wrong",
+	})
+	assert.Error(t, err)
+}
+
+func TestOpenStackInstanceExists(t *testing.T) {
+	deleted := openStackFakeNovaServer("uuid-2", "runner-abc123-test-2", time.Now())
+	deleted["status"] = "DELETED"
+
+	fake, client := newOpenStackFakeServer(t, [][]map[string]interface{}{
+		{openStackFakeNovaServer("uuid-1", "runner-abc123-test-1", time.Now()), deleted},
+	})
+	defer fake.Close()
+
+	testInstanceExists(t, client, "uuid-1", "uuid-3", "uuid-forbidden")
+
+	exists, err := client.InstanceExists(Instance{ID: "uuid-2"})
+	assert.NoError(t, err)
+	assert.False(t, exists, "Server in DELETED state should be reported as deleted")
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"time"
+)
+
+// Instance is a provider-neutral representation of a cloud machine created
+// by Docker Machine
+type Instance struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+	Region    string
+	Size      string
+	Tags      []string
+	Status    string
+	Provider  string
+}
+
+// ActionError is returned when an asynchronous provider action (e.g.
+// power-off) finished with a failure or didn't finish in time
+type ActionError struct {
+	Action     string
+	InstanceID string
+	Status     string
+	Timeout    bool
+}
+
+func (e *ActionError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("%s action for instance %s didn't complete in time (last status: %s)", e.Action, e.InstanceID, e.Status)
+	}
+
+	return fmt.Sprintf("%s action for instance %s finished with status: %s", e.Action, e.InstanceID, e.Status)
+}
+
+func IsActionTimeout(err error) bool {
+	actionErr, ok := err.(*ActionError)
+	return ok && actionErr.Timeout
+}
+
+type CloudProvider interface {
+	Name() string
+	ListInstances(*regexp.Regexp, time.Duration) ([]Instance, error)
+	StopInstance(Instance) error
+	DeleteInstance(Instance) error
+	InstanceExists(Instance) (bool, error)
+}
+
+type Snapshot struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// InstanceSnapshotter is implemented by providers that can snapshot
+// instances, which is required by the snapshot-before-delete option
+type InstanceSnapshotter interface {
+	SnapshotInstance(instance Instance, name string) (string, error)
+	ListSnapshots(namePrefix string) ([]Snapshot, error)
+	DeleteSnapshot(snapshot Snapshot) error
+}
+
+// SSHKeyRemover is implemented by providers on which Docker Machine drivers
+// register a per-machine SSH key; `docker-machine rm` removes the key
+// together with the machine
+type SSHKeyRemover interface {
+	RemoveSSHKey(id string) error
+}
+
+// InstanceTagger is implemented by providers that can tag instances, which
+// is required by the quarantine policy
+type InstanceTagger interface {
+	TagInstance(instance Instance, tag string) error
+	UntagInstance(instance Instance, tag string) error
+}
+
+func selectInstances(prefixRegexp *regexp.Regexp, age time.Duration, instancesList []Instance) (instances []Instance) {
+	for _, instance := range instancesList {
+		if !prefixRegexp.MatchString(instance.Name) {
+			continue
+		}
+
+		if instance.CreatedAt.IsZero() || time.Now().Sub(instance.CreatedAt) < age {
+			continue
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+// namePrefixes returns literal prefixes that every name matched by the
+// anchored prefix regexp starts with, so providers can narrow the listing on
+// their side. Nil is returned when such prefixes can't be found
+func namePrefixes(prefixRegexp *regexp.Regexp) []string {
+	parsed, err := syntax.Parse(prefixRegexp.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+
+	if parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 || parsed.Sub[0].Op != syntax.OpBeginText {
+		return nil
+	}
+
+	return literalPrefixes(parsed.Sub[1])
+}
+
+func literalPrefixes(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpConcat:
+		return literalPrefixes(re.Sub[0])
+	case syntax.OpAlternate:
+		var prefixes []string
+		for _, sub := range re.Sub {
+			subPrefixes := literalPrefixes(sub)
+			if subPrefixes == nil {
+				return nil
+			}
+			prefixes = append(prefixes, subPrefixes...)
+		}
+		return prefixes
+	}
+
+	return nil
+}
+
+// labelsToTags flattens key/value labels into tags in the `key:value` form,
+// or just `key` when the value is empty
+func labelsToTags(labels map[string]string) (tags []string) {
+	for key, value := range labels {
+		if value != "" {
+			key = key + ":" + value
+		}
+		tags = append(tags, key)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
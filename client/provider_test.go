@@ -0,0 +1,40 @@
+package client
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testInstanceExists checks that only a "not found" answer is reported as
+// deleted instance; any other failure must be returned as an error, as the
+// instance may still exist
+func testInstanceExists(t *testing.T, provider CloudProvider, existingID string, missingID string, failingID string) {
+	exists, err := provider.InstanceExists(Instance{ID: existingID})
+	assert.NoError(t, err)
+	assert.True(t, exists, "Existing instance should be found")
+
+	exists, err = provider.InstanceExists(Instance{ID: missingID})
+	assert.NoError(t, err)
+	assert.False(t, exists, "Missing instance should be reported as deleted")
+
+	_, err = provider.InstanceExists(Instance{ID: failingID})
+	assert.Error(t, err, "API error shouldn't be reported as deleted instance")
+}
+
+func TestNamePrefixes(t *testing.T) {
+	examples := map[string][]string{
+		"^(runner-abc123)":            {"runner-abc123"},
+		"^(runner-abc123|other-x)":    {"runner-abc123", "other-x"},
+		"^(runner-abc123|runner-def)": {"runner-"},
+		"^(runner-[0-9]+)":            {"runner-"},
+		"^([a-z]+)":                   nil,
+		"^(runner|(?i)other)":         nil,
+		"runner":                      nil,
+	}
+
+	for pattern, expected := range examples {
+		assert.Equal(t, expected, namePrefixes(regexp.MustCompile(pattern)), pattern)
+	}
+}
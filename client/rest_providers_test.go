@@ -0,0 +1,152 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type restFakeServer struct {
+	*httptest.Server
+
+	pages    map[string]string
+	requests []string
+}
+
+// restFakeForbiddenPath always responds with 403
+const restFakeForbiddenPath = "/forbidden"
+
+func (s *restFakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	request := r.Method + " " + r.URL.Path
+	s.requests = append(s.requests, request)
+
+	if strings.HasSuffix(r.URL.Path, restFakeForbiddenPath) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	page, ok := s.pages[r.URL.RequestURI()]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	fmt.Fprint(w, page)
+}
+
+func newRestFakeServer(pages map[string]string) *restFakeServer {
+	fake := &restFakeServer{pages: pages}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+
+	return fake
+}
+
+func testRestProvider(t *testing.T, provider CloudProvider, fake *restFakeServer, expectedIDs []string, stopPath string, deletePath string) {
+	instances, err := provider.ListInstances(regexp.MustCompile("^runner-abc123"), 10*time.Minute)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+		assert.Equal(t, provider.Name(), instance.Provider)
+	}
+	assert.Equal(t, expectedIDs, ids)
+
+	fake.requests = nil
+	assert.NoError(t, provider.StopInstance(instances[0]))
+	assert.NoError(t, provider.DeleteInstance(instances[0]))
+	assert.Equal(t, []string{stopPath, deletePath}, fake.requests)
+}
+
+func TestHetznerProvider(t *testing.T) {
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	fake := newRestFakeServer(map[string]string{
+		"/servers?page=1&per_page=50": `{"servers": [
+			{"id": 1, "name": "runner-abc123-test-1", "status": "running", "created": "` + old + `", "labels": {"keep": ""}},
+			{"id": 2, "name": "other-machine", "status": "running", "created": "` + old + `"}
+		], "meta": {"pagination": {"next_page": 2}}}`,
+		"/servers?page=2&per_page=50": `{"servers": [
+			{"id": 3, "name": "runner-abc123-test-3", "status": "running", "created": "` + old + `"},
+			{"id": 4, "name": "runner-abc123-test-4", "status": "running", "created": "` + time.Now().UTC().Format(time.RFC3339) + `"}
+		], "meta": {"pagination": {"next_page": null}}}`,
+	})
+	defer fake.Close()
+
+	testRestProvider(t, NewHetznerClient("token", fake.URL), fake, []string{"1", "3"},
+		"POST /servers/1/actions/poweroff", "DELETE /servers/1")
+}
+
+func TestLinodeProvider(t *testing.T) {
+	old := time.Now().Add(-time.Hour).UTC().Format(linodeTimeFormat)
+
+	fake := newRestFakeServer(map[string]string{
+		"/linode/instances?page=1&page_size=500": `{"data": [
+			{"id": 11, "label": "runner-abc123-test-1", "status": "running", "created": "` + old + `", "tags": ["keep"]}
+		], "page": 1, "pages": 2}`,
+		"/linode/instances?page=2&page_size=500": `{"data": [
+			{"id": 12, "label": "runner-abc123-test-2", "status": "running", "created": "` + old + `"},
+			{"id": 13, "label": "other-machine", "status": "running", "created": "` + old + `"}
+		], "page": 2, "pages": 2}`,
+	})
+	defer fake.Close()
+
+	testRestProvider(t, NewLinodeClient("token", fake.URL), fake, []string{"11", "12"},
+		"POST /linode/instances/11/shutdown", "DELETE /linode/instances/11")
+}
+
+func TestVultrProvider(t *testing.T) {
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	fake := newRestFakeServer(map[string]string{
+		"/instances?per_page=500": `{"instances": [
+			{"id": "a-1", "label": "runner-abc123-test-1", "power_status": "running", "date_created": "` + old + `"}
+		], "meta": {"links": {"next": "cursor-2"}}}`,
+		"/instances?cursor=cursor-2&per_page=500": `{"instances": [
+			{"id": "a-2", "label": "runner-abc123-test-2", "power_status": "stopped", "date_created": "` + old + `"}
+		], "meta": {"links": {"next": ""}}}`,
+	})
+	defer fake.Close()
+
+	testRestProvider(t, NewVultrClient("token", fake.URL), fake, []string{"a-1", "a-2"},
+		"POST /instances/a-1/halt", "DELETE /instances/a-1")
+}
+
+func TestHetznerInstanceExists(t *testing.T) {
+	fake := newRestFakeServer(map[string]string{
+		"/servers/1": `{"server": {"id": 1}}`,
+	})
+	defer fake.Close()
+
+	testInstanceExists(t, NewHetznerClient("token", fake.URL), "1", "2", "forbidden")
+}
+
+func TestLinodeInstanceExists(t *testing.T) {
+	fake := newRestFakeServer(map[string]string{
+		"/linode/instances/11": `{"id": 11}`,
+	})
+	defer fake.Close()
+
+	testInstanceExists(t, NewLinodeClient("token", fake.URL), "11", "12", "forbidden")
+}
+
+func TestVultrInstanceExists(t *testing.T) {
+	fake := newRestFakeServer(map[string]string{
+		"/instances/a-1": `{"instance": {"id": "a-1"}}`,
+	})
+	defer fake.Close()
+
+	testInstanceExists(t, NewVultrClient("token", fake.URL), "a-1", "a-2", "forbidden")
+}
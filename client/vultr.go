@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client/internal/rest"
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/version"
+)
+
+const (
+	VultrProviderName = "vultr"
+
+	vultrDefaultEndpoint = "https://api.vultr.com/v2"
+	vultrPageSize        = 500
+)
+
+type vultrInstance struct {
+	ID          string   `json:"id"`
+	Label       string   `json:"label"`
+	Status      string   `json:"status"`
+	PowerStatus string   `json:"power_status"`
+	DateCreated string   `json:"date_created"`
+	Region      string   `json:"region"`
+	Plan        string   `json:"plan"`
+	Tags        []string `json:"tags"`
+}
+
+type vultrInstancesPage struct {
+	Instances []vultrInstance `json:"instances"`
+	Meta      struct {
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"meta"`
+}
+
+type VultrClient struct {
+	client *rest.Client
+}
+
+func (c *VultrClient) Name() string {
+	return VultrProviderName
+}
+
+func (c *VultrClient) vultrInstanceToInstance(vultr vultrInstance) Instance {
+	instance := Instance{
+		ID:       vultr.ID,
+		Name:     vultr.Label,
+		Region:   vultr.Region,
+		Size:     vultr.Plan,
+		Tags:     vultr.Tags,
+		Status:   vultr.PowerStatus,
+		Provider: VultrProviderName,
+	}
+
+	if instance.Status == "" {
+		instance.Status = vultr.Status
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, vultr.DateCreated); err == nil {
+		instance.CreatedAt = createdAt
+	}
+
+	return instance
+}
+
+func (c *VultrClient) ListInstances(instancesPrefixRegexp *regexp.Regexp, instanceAge time.Duration) (instances []Instance, err error) {
+	err = rest.Paginate(context.Background(), "", func(ctx context.Context, cursor string) (string, error) {
+		query := url.Values{}
+		query.Set("per_page", strconv.Itoa(vultrPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var instancesPage vultrInstancesPage
+		_, err := c.client.Do(ctx, http.MethodGet, "/instances", query, nil, &instancesPage)
+		if err != nil {
+			return "", err
+		}
+
+		var instancesList []Instance
+		for _, vultr := range instancesPage.Instances {
+			instancesList = append(instancesList, c.vultrInstanceToInstance(vultr))
+		}
+		instances = append(instances, selectInstances(instancesPrefixRegexp, instanceAge, instancesList)...)
+
+		return instancesPage.Meta.Links.Next, nil
+	})
+
+	return
+}
+
+func (c *VultrClient) StopInstance(instance Instance) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancelFn()
+
+	_, err := c.client.Do(ctx, http.MethodPost, fmt.Sprintf("/instances/%s/halt", url.PathEscape(instance.ID)), nil, nil, nil)
+	return err
+}
+
+func (c *VultrClient) DeleteInstance(instance Instance) error {
+	return c.client.Delete(context.Background(), fmt.Sprintf("/instances/%s", url.PathEscape(instance.ID)))
+}
+
+func (c *VultrClient) InstanceExists(instance Instance) (bool, error) {
+	return c.client.Exists(context.Background(), fmt.Sprintf("/instances/%s", url.PathEscape(instance.ID)))
+}
+
+func NewVultrClient(apiToken string, endpoint string) *VultrClient {
+	if endpoint == "" {
+		endpoint = vultrDefaultEndpoint
+	}
+
+	return &VultrClient{
+		client: &rest.Client{
+			BaseURL:    endpoint,
+			Token:      apiToken,
+			UserAgent:  version.AppVersion.UserAgent(),
+			MaxRetries: rest.DefaultMaxRetries,
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/cleaner"
+)
+
+const (
+	DefaultAgentListen          = "0.0.0.0:9381"
+	DefaultAgentRefreshInterval = 10
+)
+
+type AgentCommand struct {
+	handler *cleaner.InventoryHandler
+
+	listenAddr  string
+	tlsCertFile string
+	tlsKeyFile  string
+	interval    time.Duration
+}
+
+func (a *AgentCommand) refresh() {
+	if err := a.handler.Refresh(); err != nil {
+		logrus.Errorf("Error while listing machines: %v", err.Error())
+	}
+}
+
+func (a *AgentCommand) run() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.refresh()
+	}
+}
+
+func (a *AgentCommand) Execute(context *cli.Context) {
+	logrus.Infoln("Running in agent mode")
+
+	token := context.String("token")
+	if token == "" {
+		logrus.Fatalln("Failed to start agent: 'token' must be set")
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		logrus.Warnf("Couldn't read the hostname: %v", err.Error())
+	}
+
+	if context.Int("refresh-interval") <= 0 {
+		logrus.Fatalln("Failed to start agent: 'refresh-interval' must be greater than 0")
+	}
+
+	a.listenAddr = context.String("listen")
+	a.tlsCertFile = context.String("tls-cert-file")
+	a.tlsKeyFile = context.String("tls-key-file")
+	if (a.tlsCertFile == "") != (a.tlsKeyFile == "") {
+		logrus.Fatalln("Failed to start agent: both 'tls-cert-file' and 'tls-key-file' must be set")
+	}
+
+	a.interval = time.Duration(context.Int("refresh-interval")) * time.Second
+	a.handler = cleaner.NewInventoryHandler(newMachinesFinder(context), token, host)
+
+	logrus.Infof("Machines inventory refresh interval: %s", a.interval)
+
+	a.refresh()
+	go a.run()
+
+	mux := http.NewServeMux()
+	mux.Handle("/inventory", a.handler)
+
+	if a.tlsCertFile == "" {
+		logrus.Warnln("TLS is not configured; the token and the inventory are sent in cleartext")
+		logrus.Infof("Machines inventory listening at: http://%s/inventory", a.listenAddr)
+		logrus.Fatalln(http.ListenAndServe(a.listenAddr, mux))
+	}
+
+	logrus.Infof("Machines inventory listening at: https://%s/inventory", a.listenAddr)
+	logrus.Fatalln(http.ListenAndServeTLS(a.listenAddr, a.tlsCertFile, a.tlsKeyFile, mux))
+}
+
+func NewAgentCommand() *cli.Command {
+	cmd := &AgentCommand{}
+
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "Address on which the machines inventory is served",
+			Value: DefaultAgentListen,
+			EnvVars: []string{
+				"LISTEN",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert-file",
+			Usage: "Path to the TLS certificate (with intermediates) used to serve the inventory over HTTPS",
+			EnvVars: []string{
+				"TLS_CERT_FILE",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "tls-key-file",
+			Usage: "Path to the private key of the TLS certificate",
+			EnvVars: []string{
+				"TLS_KEY_FILE",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "Token which clients must send in the 'Authorization: Bearer' header",
+			EnvVars: []string{
+				"AGENT_TOKEN",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "refresh-interval",
+			Usage: "Number of seconds between machines directory scans",
+			Value: DefaultAgentRefreshInterval,
+			EnvVars: []string{
+				"REFRESH_INTERVAL",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "machines-directory",
+			Usage: "Absolute path to directory where Docker Machine machines configuration is stored; may be used multiple times",
+			Value: cli.NewStringSlice("/root/.docker/machine/machines"),
+			EnvVars: []string{
+				"MACHINES_DIRECTORY",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "machines-directory-marker",
+			Usage: "Name of a file that must exist in machines directory; if it's missing the inventory is not served",
+			EnvVars: []string{
+				"MACHINES_DIRECTORY_MARKER",
+			},
+		},
+	}
+
+	return &cli.Command{
+		Name:  "agent",
+		Usage: "Serve the inventory of local Docker Machine machines for cleaners running on other hosts",
+		Action: func(c *cli.Context) error {
+			cmd.Execute(c)
+			return nil
+		},
+		Flags: flags,
+	}
+}
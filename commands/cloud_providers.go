@@ -0,0 +1,339 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"gitlab.com/tmaczukin/hanging-droplets-cleaner/client"
+)
+
+type cloudProviderFactory func(context *cli.Context) (client.CloudProvider, error)
+
+var cloudProviderFactories = map[string]cloudProviderFactory{
+	client.DigitalOceanProviderName: newDigitalOceanProvider,
+	client.AmazonEC2ProviderName:    newAmazonEC2Provider,
+	client.GoogleProviderName:       newGoogleProvider,
+	client.HetznerProviderName:      newHetznerProvider,
+	client.LinodeProviderName:       newLinodeProvider,
+	client.OpenStackProviderName:    newOpenStackProvider,
+	client.VultrProviderName:        newVultrProvider,
+}
+
+func cloudProviderNames() []string {
+	var names []string
+	for name := range cloudProviderFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newCloudProvider(context *cli.Context) (client.CloudProvider, error) {
+	name := context.String("provider")
+
+	factory, ok := cloudProviderFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown provider '%s'. Supported providers: %s", name, strings.Join(cloudProviderNames(), ", "))
+	}
+
+	return factory(context)
+}
+
+func newDigitalOceanProvider(context *cli.Context) (client.CloudProvider, error) {
+	apiToken := context.String("digitalocean-token")
+	if apiToken == "" {
+		return nil, fmt.Errorf("Missing DigitalOcean API Token")
+	}
+
+	return client.NewDigitalOceanClient(client.DigitalOceanConfig{
+		Token:            apiToken,
+		ActionTimeout:    time.Duration(context.Int("digitalocean-action-timeout")) * time.Second,
+		RateLimitReserve: context.Int("digitalocean-rate-limit-reserve"),
+		MaxRetries:       context.Int("digitalocean-max-retries"),
+		Tags:             context.StringSlice("digitalocean-tags"),
+		SnapshotTimeout:  time.Duration(context.Int("digitalocean-snapshot-timeout")) * time.Second,
+	}), nil
+}
+
+func newAmazonEC2Provider(context *cli.Context) (client.CloudProvider, error) {
+	return client.NewAmazonEC2Client(client.AmazonEC2Config{
+		AccessKey: context.String("amazonec2-access-key"),
+		SecretKey: context.String("amazonec2-secret-key"),
+		Region:    context.String("amazonec2-region"),
+		Endpoint:  context.String("amazonec2-endpoint"),
+	})
+}
+
+func newGoogleProvider(context *cli.Context) (client.CloudProvider, error) {
+	return client.NewGoogleClient(client.GoogleConfig{
+		Project:         context.String("google-project"),
+		Zones:           context.StringSlice("google-zone"),
+		CredentialsFile: context.String("google-credentials-file"),
+		Endpoint:        context.String("google-endpoint"),
+	})
+}
+
+func newOpenStackProvider(context *cli.Context) (client.CloudProvider, error) {
+	return client.NewOpenStackClient(client.OpenStackConfig{
+		AuthURL:    context.String("openstack-auth-url"),
+		Username:   context.String("openstack-username"),
+		Password:   context.String("openstack-password"),
+		DomainName: context.String("openstack-domain-name"),
+		TenantName: context.String("openstack-tenant-name"),
+		TenantID:   context.String("openstack-tenant-id"),
+		Region:     context.String("openstack-region"),
+		Interface:  context.String("openstack-endpoint-type"),
+	})
+}
+
+func requireToken(context *cli.Context, flag string, provider string) (string, error) {
+	token := context.String(flag)
+	if token == "" {
+		return "", fmt.Errorf("Missing %s API Token", provider)
+	}
+
+	return token, nil
+}
+
+func newHetznerProvider(context *cli.Context) (client.CloudProvider, error) {
+	token, err := requireToken(context, "hetzner-api-token", "Hetzner Cloud")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewHetznerClient(token, context.String("hetzner-endpoint")), nil
+}
+
+func newLinodeProvider(context *cli.Context) (client.CloudProvider, error) {
+	token, err := requireToken(context, "linode-token", "Linode")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewLinodeClient(token, context.String("linode-endpoint")), nil
+}
+
+func newVultrProvider(context *cli.Context) (client.CloudProvider, error) {
+	token, err := requireToken(context, "vultr-api-key", "Vultr")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewVultrClient(token, context.String("vultr-endpoint")), nil
+}
+
+func cloudProvidersFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "provider",
+			Usage: fmt.Sprintf("Cloud provider where Docker Machine creates instances (%s)", strings.Join(cloudProviderNames(), ", ")),
+			Value: client.DigitalOceanProviderName,
+			EnvVars: []string{
+				"PROVIDER",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "digitalocean-token",
+			Usage: "DigitalOcean API Token",
+			EnvVars: []string{
+				"DIGITALOCEAN_TOKEN",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "digitalocean-action-timeout",
+			Usage: "Number of seconds to wait for a droplet action (e.g. power-off) to complete",
+			Value: int(client.DefaultDigitalOceanActionTimeout / time.Second),
+			EnvVars: []string{
+				"DIGITALOCEAN_ACTION_TIMEOUT",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "digitalocean-snapshot-timeout",
+			Usage: "Number of seconds to wait for a droplet snapshot to complete",
+			Value: int(client.DefaultDigitalOceanSnapshotTimeout / time.Second),
+			EnvVars: []string{
+				"DIGITALOCEAN_SNAPSHOT_TIMEOUT",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "digitalocean-tags",
+			Usage: "List only droplets having one of these tags (server side filtering) instead of scanning the whole account; may be used multiple times",
+			EnvVars: []string{
+				"DIGITALOCEAN_TAGS",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "digitalocean-rate-limit-reserve",
+			Usage: "Number of DigitalOcean API requests left for other users of the token; when the remaining budget drops to it, the cleaner waits for the rate limit reset",
+			Value: client.DefaultDigitalOceanRateLimitReserve,
+			EnvVars: []string{
+				"DIGITALOCEAN_RATE_LIMIT_RESERVE",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "digitalocean-max-retries",
+			Usage: "Number of retries for DigitalOcean API requests failing with 429 or 5xx status",
+			Value: client.DefaultDigitalOceanMaxRetries,
+			EnvVars: []string{
+				"DIGITALOCEAN_MAX_RETRIES",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "amazonec2-access-key",
+			Usage: "AWS Access Key; if empty the default AWS credentials chain is used",
+			EnvVars: []string{
+				"AWS_ACCESS_KEY_ID",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "amazonec2-secret-key",
+			Usage: "AWS Secret Key",
+			EnvVars: []string{
+				"AWS_SECRET_ACCESS_KEY",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "amazonec2-region",
+			Usage: "AWS region where instances are created",
+			Value: "us-east-1",
+			EnvVars: []string{
+				"AWS_DEFAULT_REGION",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "amazonec2-endpoint",
+			Usage: "Custom EC2 API endpoint (e.g. for EC2-compatible clouds)",
+			EnvVars: []string{
+				"AWS_EC2_ENDPOINT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "google-project",
+			Usage: "Google Cloud project ID",
+			EnvVars: []string{
+				"GOOGLE_PROJECT",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "google-zone",
+			Usage: "Google Compute Engine zone to scan; if not set, all zones of the project are scanned",
+		},
+		&cli.StringFlag{
+			Name:  "google-credentials-file",
+			Usage: "Path to service account JSON key; if empty, Application Default Credentials are used",
+			EnvVars: []string{
+				"GOOGLE_APPLICATION_CREDENTIALS",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "google-endpoint",
+			Usage: "Custom Compute Engine API endpoint",
+			EnvVars: []string{
+				"GOOGLE_COMPUTE_ENDPOINT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "hetzner-api-token",
+			Usage: "Hetzner Cloud API Token",
+			EnvVars: []string{
+				"HETZNER_API_TOKEN",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "hetzner-endpoint",
+			Usage: "Custom Hetzner Cloud API endpoint",
+			EnvVars: []string{
+				"HETZNER_ENDPOINT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "linode-token",
+			Usage: "Linode API Token",
+			EnvVars: []string{
+				"LINODE_TOKEN",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "linode-endpoint",
+			Usage: "Custom Linode API endpoint",
+			EnvVars: []string{
+				"LINODE_ENDPOINT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "vultr-api-key",
+			Usage: "Vultr API Token",
+			EnvVars: []string{
+				"VULTR_API_KEY",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "vultr-endpoint",
+			Usage: "Custom Vultr API endpoint",
+			EnvVars: []string{
+				"VULTR_ENDPOINT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-auth-url",
+			Usage: "OpenStack Keystone v3 authentication URL",
+			EnvVars: []string{
+				"OS_AUTH_URL",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-username",
+			Usage: "OpenStack username",
+			EnvVars: []string{
+				"OS_USERNAME",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-password",
+			Usage: "OpenStack password",
+			EnvVars: []string{
+				"OS_PASSWORD",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-domain-name",
+			Usage: "OpenStack domain name",
+			EnvVars: []string{
+				"OS_DOMAIN_NAME",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-tenant-name",
+			Usage: "OpenStack tenant (project) name",
+			EnvVars: []string{
+				"OS_TENANT_NAME",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-tenant-id",
+			Usage: "OpenStack tenant (project) ID",
+			EnvVars: []string{
+				"OS_TENANT_ID",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-region",
+			Usage: "OpenStack region",
+			EnvVars: []string{
+				"OS_REGION_NAME",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "openstack-endpoint-type",
+			Usage: "OpenStack endpoint type (public, internal or admin)",
+			EnvVars: []string{
+				"OS_ENDPOINT_TYPE",
+			},
+		},
+	}
+}
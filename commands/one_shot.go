@@ -43,6 +43,8 @@ func (o *OneShotCommand) Execute(context *cli.Context) {
 	if err := cleaner.Clean(); err != nil {
 		logrus.Fatalf("Error during cleanup: %v", err.Error())
 	}
+
+	cleaner.FinishDeletionVerifications()
 }
 
 func NewOneShotCommand() *cli.Command {
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"time"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
 
@@ -10,16 +12,52 @@ import (
 
 type CleanerProvider struct{}
 
+func (s *CleanerProvider) getCloudProvider(context *cli.Context) client.CloudProvider {
+	cloudProvider, err := newCloudProvider(context)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize cloud provider: %v. Exiting", err.Error())
+	}
+
+	logrus.Infof("Using cloud provider: %s", cloudProvider.Name())
+
+	return cloudProvider
+}
+
+// newMachinesFinder builds the finder of the machines from the
+// 'machines-directory' and 'machines-inventory-url' flags. It's shared by
+// the cleaner commands and the agent, which defines only the directory flags
+func newMachinesFinder(context *cli.Context) cleaner.MachinesFinderInterface {
+	var finders []cleaner.MachinesFinderInterface
+
+	for _, directory := range context.StringSlice("machines-directory") {
+		machinesFinder := cleaner.NewMachinesFinder(directory)
+		machinesFinder.SetMarkerFile(context.String("machines-directory-marker"))
+		finders = append(finders, machinesFinder)
+	}
+
+	for _, url := range context.StringSlice("machines-inventory-url") {
+		finders = append(finders, cleaner.NewRemoteMachinesFinder(url, context.String("machines-inventory-token")))
+	}
+
+	if len(finders) == 1 {
+		return finders[0]
+	}
+
+	return cleaner.NewMultiMachinesFinder(finders...)
+}
+
 func (s *CleanerProvider) GetCleaner(context *cli.Context) *cleaner.HangingDropletsCleaner {
-	apiToken := context.String("digitalocean-token")
-	if apiToken == "" {
-		logrus.Fatalln("Missing DigitalOcean API Token. Exiting")
+	machinesFinder := newMachinesFinder(context)
+	if machinesFinder.GetMachinesDirectory() == "" {
+		logrus.Fatalf("Failed to start HangingDropletsCleaner: at least one 'machines-directory' must be set")
 	}
 
+	cloudProvider := s.getCloudProvider(context)
+
 	var err error
-	cleaner, err := cleaner.NewHangingDropletsCleaner(
-		client.NewDigitalOceanClient(apiToken),
-		cleaner.NewMachinesFinder(context.String("machines-directory")),
+	dropletsCleaner, err := cleaner.NewHangingDropletsCleaner(
+		cloudProvider,
+		machinesFinder,
 		context.Int("droplet-age"),
 		context.StringSlice("runner-prefix"),
 	)
@@ -28,24 +66,100 @@ func (s *CleanerProvider) GetCleaner(context *cli.Context) *cleaner.HangingDropl
 		logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
 	}
 
-	return cleaner
+	machineStore := cleaner.NewDockerMachineStore(machinesFinder.GetMachinesDirectory(), cloudProvider)
+	machineStore.SetLockDirectory(context.String("machines-lock-directory"))
+	dropletsCleaner.SetMachineStore(machineStore)
+
+	if err := dropletsCleaner.SetStopMode(context.String("stop-mode")); err != nil {
+		logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
+	}
+
+	err = dropletsCleaner.SetSupersededPolicy(context.String("superseded-policy"))
+	if err != nil {
+		logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
+	}
+
+	err = dropletsCleaner.SetPolicy(context.String("policy"), time.Duration(context.Int("quarantine-hold"))*time.Second)
+	if err != nil {
+		logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
+	}
+
+	err = dropletsCleaner.SetSnapshotPolicy(context.StringSlice("snapshot-prefix"), time.Duration(context.Int("snapshot-retention"))*time.Second)
+	if err != nil {
+		logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
+	}
+
+	if auditLog := context.String("audit-log"); auditLog != "" {
+		dropletsCleaner.SetAuditLog(cleaner.NewFileAuditLog(auditLog))
+	}
+
+	dropletsCleaner.SetDeletionLimits(context.Int("max-deletions"), context.Float64("max-deletions-percent"), context.Bool("override-deletion-limits"))
+	dropletsCleaner.SetZombieFolderPolicy(
+		time.Duration(context.Int("zombie-folder-min-age"))*time.Second,
+		context.String("zombie-folder-archive-directory"),
+		time.Duration(context.Int("zombie-folder-archive-retention"))*time.Second,
+	)
+
+	dropletsCleaner.SetConcurrency(context.Int("concurrency"))
+
+	confirmations := context.Int("hanging-confirmations")
+	minDuration := time.Duration(context.Int("hanging-min-duration")) * time.Second
+	if confirmations > 1 || minDuration > 0 {
+		err = dropletsCleaner.EnableCandidateTracking(confirmations, minDuration, context.String("state-file"))
+		if err != nil {
+			logrus.Fatalf("Failed to start HangingDropletsCleaner: %v", err.Error())
+		}
+	}
+	dropletsCleaner.SetRequiredTags(context.StringSlice("required-tag"))
+	dropletsCleaner.SetProtection(context.StringSlice("protect-tag"), context.StringSlice("protect-droplet"))
+
+	if verifyDelay := context.Int("verify-delay"); verifyDelay > 0 {
+		dropletsCleaner.EnableDeleteVerification(time.Duration(verifyDelay)*time.Second, context.Int("verify-max-failures"))
+	}
+
+	if webhookURL := context.String("notify-webhook-url"); webhookURL != "" {
+		dropletsCleaner.SetNotifier(cleaner.NewWebhookNotifier(webhookURL))
+	}
+
+	return dropletsCleaner
 }
 
 func (s *CleanerProvider) Flags() []cli.Flag {
-	return []cli.Flag{
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "machines-directory",
+			Usage: "Absolute path to directory where Docker Machine machines configuration is stored; may be used multiple times",
+			Value: cli.NewStringSlice("/root/.docker/machine/machines"),
+			EnvVars: []string{
+				"MACHINES_DIRECTORY",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "machines-inventory-url",
+			Usage: "URL of machines inventory exported by the agent running on another host using the same cloud account; may be used multiple times",
+			EnvVars: []string{
+				"MACHINES_INVENTORY_URLS",
+			},
+		},
 		&cli.StringFlag{
-			Name:   "digitalocean-token",
-			Usage:  "DigitalOcean API Token",
+			Name:  "machines-inventory-token",
+			Usage: "Token used to authenticate to machines inventories",
 			EnvVars: []string{
-				"DIGITALOCEAN_TOKEN",
+				"MACHINES_INVENTORY_TOKEN",
 			},
 		},
 		&cli.StringFlag{
-			Name:   "machines-directory",
-			Usage:  "Absolute path to directory where Docker Machine machines configuration is stored",
-			Value:  "/root/.docker/machine/machines",
+			Name:  "machines-directory-marker",
+			Usage: "Name of a file that must exist in machines directory; if it's missing the cleanup is aborted",
 			EnvVars: []string{
-				"MACHINES_DIRECTORY",
+				"MACHINES_DIRECTORY_MARKER",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "machines-lock-directory",
+			Usage: "Directory where lock files shared with other tools working on machines directory are created; defaults to 'locks' next to machines directory",
+			EnvVars: []string{
+				"MACHINES_LOCK_DIRECTORY",
 			},
 		},
 		&cli.IntFlag{
@@ -60,5 +174,182 @@ func (s *CleanerProvider) Flags() []cli.Flag {
 			Name:  "runner-prefix",
 			Usage: "Prefix of runner's droplet name",
 		},
+		&cli.StringFlag{
+			Name:  "stop-mode",
+			Usage: "How droplets are stopped before deletion: 'power-off' (power off and wait for the action to complete) or 'skip' (delete without powering off)",
+			Value: string(cleaner.StopModePowerOff),
+			EnvVars: []string{
+				"STOP_MODE",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "policy",
+			Usage: "What to do with hanging droplets: 'delete' (stop and delete) or 'quarantine' (stop and tag, delete after the quarantine hold)",
+			Value: string(cleaner.PolicyDelete),
+			EnvVars: []string{
+				"POLICY",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "superseded-policy",
+			Usage: "What to do with droplets having a name of an existing machine, but a different ID: 'delete' (handle them like hanging droplets) or 'keep' (only report them)",
+			Value: string(cleaner.SupersededPolicyDelete),
+			EnvVars: []string{
+				"SUPERSEDED_POLICY",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "quarantine-hold",
+			Usage: "Number of seconds after which quarantined droplet is deleted",
+			Value: int(cleaner.DefaultQuarantineHold / time.Second),
+			EnvVars: []string{
+				"QUARANTINE_HOLD",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "snapshot-prefix",
+			Usage: "Droplets with names starting with this prefix are snapshotted before deletion; may be used multiple times",
+			EnvVars: []string{
+				"SNAPSHOT_PREFIXES",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "snapshot-retention",
+			Usage: "Number of seconds after which snapshots taken before deletion are removed",
+			Value: int(cleaner.DefaultSnapshotRetention / time.Second),
+			EnvVars: []string{
+				"SNAPSHOT_RETENTION",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "File where deletions and snapshots are recorded (JSON document per line)",
+			EnvVars: []string{
+				"AUDIT_LOG",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "required-tag",
+			Usage: "Tag that droplet must have to be deleted; may be used multiple times",
+			EnvVars: []string{
+				"REQUIRED_TAGS",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "protect-tag",
+			Usage: "Droplets having this tag are never stopped nor deleted; may be used multiple times",
+			EnvVars: []string{
+				"PROTECT_TAGS",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "protect-droplet",
+			Usage: "Name or ID of a droplet that is never stopped nor deleted; may be used multiple times",
+			EnvVars: []string{
+				"PROTECT_DROPLETS",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "hanging-confirmations",
+			Usage: "Number of consecutive cleanup passes in which droplet must be found hanging before it's deleted",
+			Value: 1,
+			EnvVars: []string{
+				"HANGING_CONFIRMATIONS",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "hanging-min-duration",
+			Usage: "Number of seconds for which droplet must be found hanging before it's deleted",
+			Value: 0,
+			EnvVars: []string{
+				"HANGING_MIN_DURATION",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "state-file",
+			Usage: "File where hanging droplets observations are stored between restarts; if empty, the observations are kept only in memory",
+			EnvVars: []string{
+				"STATE_FILE",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "max-deletions",
+			Usage: "Maximal number of droplets deleted in one cleanup; if more droplets are found hanging none is deleted. Set to 0 to disable the limit",
+			Value: cleaner.DefaultMaxDeletions,
+			EnvVars: []string{
+				"MAX_DELETIONS",
+			},
+		},
+		&cli.Float64Flag{
+			Name:  "max-deletions-percent",
+			Usage: "Maximal percentage of droplets matching prefixes deleted in one cleanup; if more droplets are found hanging none is deleted. Set to 0 to disable the limit",
+			Value: cleaner.DefaultMaxDeletionsPercent,
+			EnvVars: []string{
+				"MAX_DELETIONS_PERCENT",
+			},
+		},
+		&cli.BoolFlag{
+			Name:  "override-deletion-limits",
+			Usage: "Delete hanging droplets even if max-deletions or max-deletions-percent is exceeded",
+			EnvVars: []string{
+				"OVERRIDE_DELETION_LIMITS",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "zombie-folder-min-age",
+			Usage: "Minimal age (in seconds) of machine folder without droplet that can be removed",
+			Value: int(cleaner.DefaultZombieFolderMinAge / time.Second),
+			EnvVars: []string{
+				"ZOMBIE_FOLDER_MIN_AGE",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "zombie-folder-archive-directory",
+			Usage: "Directory where machine folders without droplets are moved instead of being deleted",
+			EnvVars: []string{
+				"ZOMBIE_FOLDER_ARCHIVE_DIRECTORY",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "zombie-folder-archive-retention",
+			Usage: "Number of seconds after which archived machine folders are deleted",
+			Value: int(cleaner.DefaultZombieFolderArchiveRetention / time.Second),
+			EnvVars: []string{
+				"ZOMBIE_FOLDER_ARCHIVE_RETENTION",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "Number of droplets stopped and deleted in parallel",
+			Value: cleaner.DefaultConcurrency,
+			EnvVars: []string{
+				"CONCURRENCY",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "verify-delay",
+			Usage: "Number of seconds after which deleted droplets are checked again; set to 0 to disable the verification",
+			Value: 30,
+			EnvVars: []string{
+				"VERIFY_DELAY",
+			},
+		},
+		&cli.IntFlag{
+			Name:  "verify-max-failures",
+			Usage: "Number of failed deletion verifications after which the droplet is reported as undeletable",
+			Value: 3,
+			EnvVars: []string{
+				"VERIFY_MAX_FAILURES",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "notify-webhook-url",
+			Usage: "URL to which JSON notifications about problems requiring attention are POSTed",
+			EnvVars: []string{
+				"NOTIFY_WEBHOOK_URL",
+			},
+		},
 	}
+
+	return append(flags, cloudProvidersFlags()...)
 }
@@ -67,7 +67,15 @@ func (d *ServiceCommand) startDebugServer() error {
 }
 
 func (d *ServiceCommand) clean() {
-	if err := d.cleaner.Clean(); err != nil {
+	err := d.cleaner.Clean()
+	if cleaner.IsMachinesDirectoryError(err) || cleaner.IsInventoryError(err) {
+		// keep the service (and its metrics) running, the directory or
+		// the inventory may be fixed before the next cleanup
+		logrus.Errorf("Cleanup aborted: %v", err.Error())
+		return
+	}
+
+	if err != nil {
 		logrus.Fatalf("Error during cleanup: %v", err.Error())
 	}
 }
@@ -98,6 +98,7 @@ func main() {
 	app.Commands = []*cli.Command{
 		commands.NewStartCommand(),
 		commands.NewOneShotCommand(),
+		commands.NewAgentCommand(),
 	}
 
 	if err := app.Run(os.Args); err != nil {